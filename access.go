@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"go-redirector/accesslog"
+	"go-redirector/errors"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+/*
+*
+Set up the access log. Without a file it shares the application log, so its entries follow the
+log level. With a file every entry is written there, whatever the log level.
+*/
+func (c *Config) setAccessLog(format string, file string) {
+	out, leveled := os.Stderr, true
+	if file != "" {
+		opened, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Error().Msg(fmt.Sprintf("Could not open access log [%s]: %v", file, err))
+			c.exitFunc(errors.ExitCodeBadAccessLog)
+			return
+		}
+		out, leveled = opened, false
+	}
+
+	accessLog, err := accesslog.New(format, out, leveled)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("Error: %v", err))
+		c.exitFunc(errors.ExitCodeBadAccessLog)
+		return
+	}
+
+	c.AccessLogFile = file
+	c.AccessLog = accessLog
+}
+
+// logAccess writes the access log entry of a request once it has been answered, resolution is nil when nothing matched
+func (f *FastServer) logAccess(c *fiber.Ctx, origin requestOrigin, resolution *Resolution, outcome string, start time.Time) {
+	if f.Config.AccessLog == nil {
+		return
+	}
+
+	entry := accesslog.Entry{
+		Time:         start,
+		Method:       c.Method(),
+		Protocol:     string(c.Request().Header.Protocol()),
+		Scheme:       origin.Scheme,
+		Host:         origin.Host,
+		OriginalHost: c.Hostname(),
+		Path:         string(c.Request().URI().Path()),
+		Query:        string(c.Request().URI().QueryString()),
+		Outcome:      outcome,
+		Status:       c.Response().StatusCode(),
+		Bytes:        len(c.Response().Body()),
+		Latency:      time.Since(start),
+		ClientIP:     origin.IP,
+		UserAgent:    c.Get(fiber.HeaderUserAgent),
+		Referer:      c.Get(fiber.HeaderReferer),
+		RequestID:    string(c.Response().Header.Peek(f.Config.RequestIDHeader)),
+	}
+	if resolution != nil {
+		entry.MappingHost = resolution.Host
+		entry.MappingPath = resolution.Path
+		entry.Target = resolution.Target
+	}
+
+	f.Config.AccessLog.Log(&entry)
+}
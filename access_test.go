@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"go-redirector/accesslog"
+	"go-redirector/errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_SetAccessLog(t *testing.T) {
+	config := NewConfig()
+	config.exitFunc = func(code int) {
+		t.Errorf("Did not expect to see the app exit on a valid access log, code: %d", code)
+	}
+
+	file := filepath.Join(t.TempDir(), "access.log")
+	config.setAccessLog("logfmt", file)
+	if config.AccessLog == nil || config.AccessLogFile != file {
+		t.Fatalf("Expected an access log writing to [%s], got [%s]", file, config.AccessLogFile)
+	}
+	config.AccessLog.Log(&accesslog.Entry{Path: "/written"})
+	if content, _ := ioutil.ReadFile(file); !strings.Contains(string(content), "path=/written") {
+		t.Errorf("Expected the entry in [%s], got [%s]", file, content)
+	}
+
+	config.setAccessLog("off", "")
+	if config.AccessLog != nil {
+		t.Errorf("Expected no access log when off")
+	}
+
+	for _, test := range []struct {
+		format string
+		file   string
+	}{
+		{"xml", ""},
+		{"json", filepath.Join(t.TempDir(), "missing", "access.log")},
+	} {
+		exitReached := false
+		config.exitFunc = func(code int) {
+			if code != errors.ExitCodeBadAccessLog {
+				t.Errorf("Expected exit code of [%v], got [%v]", errors.ExitCodeBadAccessLog, code)
+			}
+			exitReached = true
+		}
+		config.setAccessLog(test.format, test.file)
+		if !exitReached {
+			t.Errorf("Expected the app to exit for format [%s] and file [%s]", test.format, test.file)
+		}
+	}
+}
+
+func Test_LogAccess(t *testing.T) {
+	var out bytes.Buffer
+	config := NewConfig()
+	config.setMappingFile("./tests/test-redirect-map.yml")
+	config.AccessLog, _ = accesslog.New(accesslog.FormatLogfmt, &out, false)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+
+	testData := []struct {
+		target   string
+		expected []string
+	}{
+		{"/direct?from=test", []string{"host=testhost", "path=/direct", `query="from=test"`, "mapping_host=testhost", "mapping_path=/direct", "status=302", "client_ip=0.0.0.0"}},
+		{"/missing", []string{"host=testhost", "path=/missing", `mapping_path=""`, "status=404"}},
+	}
+
+	for _, test := range testData {
+		out.Reset()
+		request := httptest.NewRequest("GET", test.target, nil)
+		request.Host = "testhost"
+		if _, err := fastServer.server.Test(request); err != nil {
+			t.Fatalf("Did not expect to get an error testing target [%s], error: %v", test.target, err)
+		}
+
+		for _, expected := range test.expected {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("Expected to find [%s] in the access log for [%s], got [%s]", expected, test.target, out.String())
+			}
+		}
+	}
+
+	// probes are not requests to redirect, they are not logged
+	out.Reset()
+	request := httptest.NewRequest("GET", "/livez", nil)
+	request.Host = "localhost"
+	if _, err := fastServer.server.Test(request); err != nil {
+		t.Fatalf("Did not expect to get an error probing, error: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Did not expect probes in the access log, got [%s]", out.String())
+	}
+}
@@ -0,0 +1,275 @@
+package accesslog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// FormatJSON writes each request as a json object, through zerolog
+	FormatJSON = "json"
+	// FormatCombined writes each request in the Apache combined log format
+	FormatCombined = "combined"
+	// FormatLogfmt writes each request as `key=value` pairs
+	FormatLogfmt = "logfmt"
+	// FormatOff writes nothing
+	FormatOff = "off"
+
+	// combinedTime is the time layout of the Apache combined log format
+	combinedTime = "02/Jan/2006:15:04:05 -0700"
+)
+
+// Formats lists every format a Logger can write
+var Formats = []string{FormatJSON, FormatCombined, FormatLogfmt, FormatOff}
+
+// Entry describes a single request and how it was answered
+type Entry struct {
+	Time         time.Time
+	Method       string
+	Protocol     string
+	Scheme       string // scheme the client used
+	Host         string // host the request was matched on
+	OriginalHost string // Host header, differs from Host when a trusted proxy forwarded another
+	Path         string
+	Query        string
+	MappingHost  string // host key of the mapping entry which matched, empty when none did
+	MappingPath  string // path key of the mapping entry which matched
+	Outcome      string
+	Target       string // uri the client was sent to
+	Status       int
+	Bytes        int
+	Latency      time.Duration
+	ClientIP     string
+	UserAgent    string
+	Referer      string
+	RequestID    string
+}
+
+// Logger writes an Entry for every request, in one of the Formats. A nil Logger writes nothing.
+type Logger struct {
+	format  string
+	leveled bool // entries are info events of the application log, so its level applies
+	json    zerolog.Logger
+	out     io.Writer
+	mu      sync.Mutex // serializes writes to out
+	buffers sync.Pool
+}
+
+// ParseFormat checks a format is one of Formats, the name is not case sensitive. Empty is FormatJSON.
+func ParseFormat(format string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		return FormatJSON, nil
+	}
+	for _, known := range Formats {
+		if format == known {
+			return format, nil
+		}
+	}
+
+	return "", fmt.Errorf("access log format [%s] is not one of [%s]", format, strings.Join(Formats, ", "))
+}
+
+/*
+*
+New is a factory which creates a Logger writing `format` to `out`. A leveled logger shares the
+application log, its entries are info events and are dropped when the log level is above info.
+Returns nil for FormatOff.
+*/
+func New(format string, out io.Writer, leveled bool) (*Logger, error) {
+	format, err := ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatOff {
+		return nil, nil
+	}
+
+	return &Logger{
+		format:  format,
+		leveled: leveled,
+		json:    zerolog.New(out),
+		out:     out,
+		buffers: sync.Pool{New: func() interface{} { return &bytes.Buffer{} }},
+	}, nil
+}
+
+// Log writes a single entry
+func (l *Logger) Log(entry *Entry) {
+	if l == nil || (l.leveled && zerolog.GlobalLevel() > zerolog.InfoLevel) {
+		return
+	}
+
+	if l.format == FormatJSON {
+		l.logJSON(entry)
+		return
+	}
+
+	buf := l.buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	if l.format == FormatCombined {
+		writeCombined(buf, entry)
+	} else {
+		writeLogfmt(buf, entry)
+	}
+
+	l.mu.Lock()
+	_, _ = l.out.Write(buf.Bytes())
+	l.mu.Unlock()
+	l.buffers.Put(buf)
+}
+
+func (l *Logger) logJSON(entry *Entry) {
+	event := l.json.Log()
+	if l.leveled {
+		event = l.json.Info()
+	}
+
+	event.
+		Time(zerolog.TimestampFieldName, entry.Time).
+		Str("method", entry.Method).
+		Str("scheme", entry.Scheme).
+		Str("host", entry.Host).
+		Str("original_host", entry.OriginalHost).
+		Str("path", entry.Path).
+		Str("query", entry.Query).
+		Str("mapping_host", entry.MappingHost).
+		Str("mapping_path", entry.MappingPath).
+		Str("outcome", entry.Outcome).
+		Str("target", entry.Target).
+		Int("status", entry.Status).
+		Int("bytes", entry.Bytes).
+		Float64("latency_ms", milliseconds(entry.Latency)).
+		Str("client_ip", entry.ClientIP).
+		Str("user_agent", entry.UserAgent).
+		Str("referer", entry.Referer).
+		Str("request_id", entry.RequestID).
+		Send()
+}
+
+func milliseconds(latency time.Duration) float64 {
+	return float64(latency) / float64(time.Millisecond)
+}
+
+// orDash is how the combined format writes an empty value
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+// writeCombined writes `host ident user [time] "request" status bytes "referer" "user agent"`
+func writeCombined(buf *bytes.Buffer, entry *Entry) {
+	buf.WriteString(orDash(entry.ClientIP))
+	buf.WriteString(" - - [")
+	buf.WriteString(entry.Time.Format(combinedTime))
+	buf.WriteString(`] "`)
+	buf.WriteString(entry.Method)
+	buf.WriteByte(' ')
+	buf.WriteString(escapeQuoted(entry.Path)) // decoded, a client could otherwise forge a line
+	if entry.Query != "" {
+		buf.WriteByte('?')
+		buf.WriteString(escapeQuoted(entry.Query))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(entry.Protocol)
+	buf.WriteString(`" `)
+	buf.WriteString(strconv.Itoa(entry.Status))
+	buf.WriteByte(' ')
+	if entry.Bytes > 0 {
+		buf.WriteString(strconv.Itoa(entry.Bytes))
+	} else {
+		buf.WriteByte('-')
+	}
+	buf.WriteString(` "`)
+	buf.WriteString(escapeQuoted(orDash(entry.Referer)))
+	buf.WriteString(`" "`)
+	buf.WriteString(escapeQuoted(orDash(entry.UserAgent)))
+	buf.WriteString("\"\n")
+}
+
+// needsEscape reports whether a value has a quote, a backslash or a control character
+func needsEscape(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if b := value[i]; b == '"' || b == '\\' || b < 0x20 || b == 0x7f {
+			return true
+		}
+	}
+
+	return false
+}
+
+// escapeQuoted escapes a value written between double quotes, control characters included so a value never spans lines
+func escapeQuoted(value string) string {
+	if !needsEscape(value) {
+		return value
+	}
+
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch b := value[i]; {
+		case b == '"' || b == '\\':
+			escaped.WriteByte('\\')
+			escaped.WriteByte(b)
+		case b == '\n':
+			escaped.WriteString(`\n`)
+		case b == '\r':
+			escaped.WriteString(`\r`)
+		case b == '\t':
+			escaped.WriteString(`\t`)
+		case b < 0x20 || b == 0x7f:
+			fmt.Fprintf(&escaped, `\x%02x`, b)
+		default:
+			escaped.WriteByte(b)
+		}
+	}
+
+	return escaped.String()
+}
+
+// writeLogfmt writes every field as `key=value`, quoting values which need it
+func writeLogfmt(buf *bytes.Buffer, entry *Entry) {
+	pair := func(key string, value string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		if value == "" || strings.Contains(value, " ") || strings.Contains(value, "=") || needsEscape(value) {
+			buf.WriteByte('"')
+			buf.WriteString(escapeQuoted(value))
+			buf.WriteByte('"')
+		} else {
+			buf.WriteString(value)
+		}
+	}
+
+	pair("time", entry.Time.Format(time.RFC3339))
+	pair("method", entry.Method)
+	pair("scheme", entry.Scheme)
+	pair("host", entry.Host)
+	pair("original_host", entry.OriginalHost)
+	pair("path", entry.Path)
+	pair("query", entry.Query)
+	pair("mapping_host", entry.MappingHost)
+	pair("mapping_path", entry.MappingPath)
+	pair("outcome", entry.Outcome)
+	pair("target", entry.Target)
+	pair("status", strconv.Itoa(entry.Status))
+	pair("bytes", strconv.Itoa(entry.Bytes))
+	pair("latency_ms", strconv.FormatFloat(milliseconds(entry.Latency), 'f', 3, 64))
+	pair("client_ip", entry.ClientIP)
+	pair("user_agent", entry.UserAgent)
+	pair("referer", entry.Referer)
+	pair("request_id", entry.RequestID)
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,166 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func newEntry() *Entry {
+	return &Entry{
+		Time:         time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
+		Method:       "GET",
+		Protocol:     "HTTP/1.1",
+		Scheme:       "https",
+		Host:         "old.example.org",
+		OriginalHost: "ingress.internal",
+		Path:         "/docs/install",
+		Query:        "lang=en",
+		MappingHost:  "old.example.org",
+		MappingPath:  "/docs/*",
+		Outcome:      "immediate",
+		Target:       "https://new.example.org/manual/install",
+		Status:       302,
+		Latency:      1500 * time.Microsecond,
+		ClientIP:     "203.0.113.9",
+		UserAgent:    `Mozilla/5.0 "quoted"`,
+		RequestID:    "abc123",
+	}
+}
+
+func Test_ParseFormat(t *testing.T) {
+	for _, format := range []string{"json", "Combined", " logfmt ", "off"} {
+		if _, err := ParseFormat(format); err != nil {
+			t.Errorf("Did not expect an error for format [%s], error: %v", format, err)
+		}
+	}
+	if format, err := ParseFormat(""); err != nil || format != FormatJSON {
+		t.Errorf("Expected an empty format to be [%s], got [%s] with error: %v", FormatJSON, format, err)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+
+	if logger, err := New(FormatOff, &bytes.Buffer{}, false); err != nil || logger != nil {
+		t.Errorf("Expected no logger when off, got [%v] with error: %v", logger, err)
+	}
+	// a nil logger writes nothing, without panicking
+	var logger *Logger
+	logger.Log(newEntry())
+}
+
+func Test_LogJSON(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(FormatJSON, &out, false)
+	if err != nil {
+		t.Fatalf("Did not expect an error, error: %v", err)
+	}
+	logger.Log(newEntry())
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &fields); err != nil {
+		t.Fatalf("Expected a json line, got [%s]: %v", out.String(), err)
+	}
+
+	expected := map[string]interface{}{
+		"host":          "old.example.org",
+		"original_host": "ingress.internal",
+		"path":          "/docs/install",
+		"query":         "lang=en",
+		"mapping_path":  "/docs/*",
+		"target":        "https://new.example.org/manual/install",
+		"status":        float64(302),
+		"latency_ms":    1.5,
+		"client_ip":     "203.0.113.9",
+		"request_id":    "abc123",
+	}
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Errorf("Expected [%s] to be [%v], got [%v]", key, value, fields[key])
+		}
+	}
+	if _, ok := fields["level"]; ok {
+		t.Errorf("Did not expect a level in a separate access log, got [%s]", out.String())
+	}
+}
+
+func Test_LogCombined(t *testing.T) {
+	var out bytes.Buffer
+	logger, _ := New(FormatCombined, &out, false)
+	logger.Log(newEntry())
+
+	expected := `203.0.113.9 - - [01/Mar/2021:10:00:00 +0000] "GET /docs/install?lang=en HTTP/1.1" 302 - "-" "Mozilla/5.0 \"quoted\""` + "\n"
+	if out.String() != expected {
+		t.Errorf("Expected [%s], got [%s]", expected, out.String())
+	}
+}
+
+func Test_LogForgedLines(t *testing.T) {
+	// the path is logged decoded, `/a%0A...%22` must not start a new line or close the quotes
+	entry := newEntry()
+	entry.Path = "/a\n203.0.113.1 - - [01/Mar/2021:10:00:00 +0000] \"GET /admin\r\x00"
+	entry.Query = "q=\""
+
+	for _, format := range []string{FormatCombined, FormatLogfmt} {
+		var out bytes.Buffer
+		logger, _ := New(format, &out, false)
+		logger.Log(entry)
+
+		if lines := strings.Count(out.String(), "\n"); lines != 1 || strings.ContainsAny(out.String(), "\r\x00") {
+			t.Errorf("Expected a single escaped line in [%s], got [%s]", format, out.String())
+		}
+		if !strings.Contains(out.String(), `/a\n203.0.113.1 - - [01/Mar/2021:10:00:00 +0000] \"GET /admin\r\x00`) {
+			t.Errorf("Expected the path escaped in [%s], got [%s]", format, out.String())
+		}
+	}
+}
+
+func Test_LogLogfmt(t *testing.T) {
+	var out bytes.Buffer
+	logger, _ := New(FormatLogfmt, &out, false)
+	logger.Log(newEntry())
+
+	for _, expected := range []string{
+		"time=2021-03-01T10:00:00Z ",
+		" path=/docs/install ",
+		" mapping_path=/docs/* ",
+		" status=302 ",
+		" latency_ms=1.500 ",
+		` user_agent="Mozilla/5.0 \"quoted\"" `,
+		` referer="" `,
+		" request_id=abc123\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected to find [%s] in [%s]", expected, out.String())
+		}
+	}
+}
+
+func Test_LogLeveled(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+
+	var out bytes.Buffer
+	leveled, _ := New(FormatJSON, &out, true)
+	separate, _ := New(FormatLogfmt, &out, false)
+
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	leveled.Log(newEntry())
+	if out.Len() != 0 {
+		t.Errorf("Expected the application log level to drop the entry, got [%s]", out.String())
+	}
+	separate.Log(newEntry())
+	if out.Len() == 0 {
+		t.Errorf("Expected a separate access log to ignore the application log level")
+	}
+
+	out.Reset()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	leveled.Log(newEntry())
+	if !strings.Contains(out.String(), `"level":"info"`) {
+		t.Errorf("Expected an info event in the application log, got [%s]", out.String())
+	}
+}
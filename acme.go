@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"go-redirector/certstore"
+	"go-redirector/errors"
+	"go-redirector/mapping"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// DefaultACMEDirectory is the default acme directory certificates are obtained from
+	DefaultACMEDirectory = acme.LetsEncryptURL
+	// DefaultACMECache is the default directory obtained certificates and the account key are cached in
+	DefaultACMECache = "./certs/acme"
+	// ACMEChallengeRoute is where the acme directory fetches http-01 challenge tokens
+	ACMEChallengeRoute = "/.well-known/acme-challenge/"
+)
+
+/*
+*
+Set up obtaining and renewing certificates for the mapped hosts from an acme `directory`, e.g. Let's
+Encrypt, instead of loading them from files. They are cached in `cacheDir`. `caFile` lists the CA
+certificates trusted when talking to the directory, for private stand-ins such as Pebble, the system
+roots are trusted when it is empty. Ignored when the server only serves plain http.
+*/
+func (c *Config) setACME(enabled bool, directory string, cacheDir string, email string, caFile string) {
+	if !enabled {
+		return
+	}
+	if c.UseHTTP {
+		log.Warn().Msg("Ignoring acme, the server only serves plain http")
+		return
+	}
+	if directory == "" {
+		directory = DefaultACMEDirectory
+	}
+	if cacheDir == "" {
+		cacheDir = DefaultACMECache
+	}
+
+	if parsed, err := url.Parse(directory); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		log.Error().Msg(fmt.Sprintf("Acme directory [%s] is not a valid http(s) url", directory))
+		c.exitFunc(errors.ExitCodeBadACME)
+		return
+	}
+
+	var roots *x509.CertPool
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			log.Error().Msg(fmt.Sprintf("Could not read acme ca [%s]: %v", caFile, err))
+			c.exitFunc(errors.ExitCodeBadACME)
+			return
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			log.Error().Msg(fmt.Sprintf("No pem encoded certificate found in acme ca [%s]", caFile))
+			c.exitFunc(errors.ExitCodeBadACME)
+			return
+		}
+	}
+
+	c.UseACME = true
+	c.ACMEDirectory = directory
+	c.ACMECache = cacheDir
+	c.ACMEEmail = email
+	c.ACMERoots = roots
+	log.Info().Msg(fmt.Sprintf("Obtaining certificates from acme directory [%s], cached in [%s].", directory, cacheDir))
+
+	if c.MappingsFile != nil {
+		for _, host := range mappedHosts(c.MappingsFile) {
+			if strings.HasPrefix(host, mapping.WildcardHostPrefix) {
+				log.Warn().Msg(fmt.Sprintf("Acme cannot obtain a certificate for wildcard host [%s], only for the hosts it is asked for by name", host))
+			}
+		}
+	}
+	if c.HTTPPort == 0 {
+		log.Info().Msg("No http port set, acme challenges are only answered over tls-alpn-01")
+	}
+}
+
+/*
+*
+hostPolicy only lets the acme manager obtain certificates for hosts with a mapping of their own,
+as found in the mapping file in use so reloads are honoured. Wildcard and default hosts are
+refused, anyone can point a name at the server.
+*/
+func (f *FastServer) hostPolicy(_ context.Context, host string) error {
+	host = f.parseHost(host) // http-01 challenges pass the Host header
+	mappingFile := f.MappingFile()
+	if mappingFile == nil || host == "localhost" {
+		return fmt.Errorf("acme: host [%s] is not mapped", host)
+	}
+
+	key, ok := mappingFile.ResolveHost(strings.ToLower(host))
+	if !ok || !strings.EqualFold(key, host) || strings.HasPrefix(key, mapping.WildcardHostPrefix) {
+		return fmt.Errorf("acme: host [%s] is not mapped", host)
+	}
+
+	return nil
+}
+
+// newACMEManager creates the acme manager obtaining the certificates of the mapped hosts
+func (f *FastServer) newACMEManager() *autocert.Manager {
+	client := &acme.Client{DirectoryURL: f.Config.ACMEDirectory}
+	if f.Config.ACMERoots != nil {
+		client.HTTPClient = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: f.Config.ACMERoots, MinVersion: tls.VersionTLS12},
+		}}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(f.Config.ACMECache),
+		HostPolicy: f.hostPolicy,
+		Email:      f.Config.ACMEEmail,
+		Client:     client,
+	}
+}
+
+// cachedLeaf parses the leaf of a certificate cached by the acme manager, the key comes first followed by the chain
+func cachedLeaf(data []byte) (*x509.Certificate, error) {
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+
+	return nil, fmt.Errorf("no certificate found")
+}
+
+// acmeCertInfos describes the certificates cached for the mapped hosts, hosts without one yet are left out
+func (f *FastServer) acmeCertInfos() []certstore.Info {
+	mappingFile := f.MappingFile()
+	if mappingFile == nil {
+		return nil
+	}
+
+	var infos []certstore.Info
+	for _, host := range mappedHosts(mappingFile) {
+		if f.hostPolicy(context.Background(), host) != nil {
+			continue
+		}
+		// ecdsa certificates are cached by host name, rsa ones for older clients with a suffix
+		for _, name := range []string{host, host + "+rsa"} {
+			data, err := f.acme.Cache.Get(context.Background(), name)
+			if err != nil {
+				continue
+			}
+			leaf, err := cachedLeaf(data)
+			if err != nil {
+				log.Warn().Msg(fmt.Sprintf("Could not read cached acme certificate [%s]: %v", name, err))
+				continue
+			}
+			infos = append(infos, certstore.Info{File: filepath.Join(f.Config.ACMECache, name), Names: leaf.DNSNames, NotAfter: leaf.NotAfter})
+		}
+	}
+
+	return infos
+}
+
+// acmeChallenge answers the http-01 challenges of the acme directory, the acme manager only offers http-01 once it is created
+func (f *FastServer) acmeChallenge() fiber.Handler {
+	handler := fasthttpadaptor.NewFastHTTPHandler(f.acme.HTTPHandler(nil))
+	return func(c *fiber.Ctx) error {
+		handler(c.Context())
+		return nil
+	}
+}
+
+// acmeTLSConfig serves the certificates of the acme manager, answering tls-alpn-01 challenges too
+func (f *FastServer) acmeTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: f.acme.GetCertificate,
+		NextProtos:     []string{"http/1.1", acme.ALPNProto},
+	}
+}
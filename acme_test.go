@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"go-redirector/errors"
+	"go-redirector/mapping"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// newACMEConfig creates a config obtaining certificates for `mappingPath` from a local acme directory
+func newACMEConfig(t *testing.T, mappingPath string) *Config {
+	config := NewConfig()
+	config.setHTTP(false, "./missing/server.pem", "./missing/server.key")
+	config.setMappingFile(mappingPath)
+	config.exitFunc = func(code int) {
+		t.Errorf("Did not expect to see the app exit, code: %d", code)
+	}
+	config.setACME(true, "https://localhost:14000/dir", t.TempDir(), "ops@example.org", "")
+	return config
+}
+
+func Test_SetACME(t *testing.T) {
+	mappingPath := writeMapping(t, map[string][2]string{"shop.example.org": {}, "*.legacy.example.org": {}})
+	config := newACMEConfig(t, mappingPath)
+	if !config.UseACME || config.ACMEDirectory != "https://localhost:14000/dir" || config.ACMEEmail != "ops@example.org" {
+		t.Errorf("Expected acme to be configured, got [%+v]", config)
+	}
+
+	// the server cert is not needed, acme obtains the certificates
+	if config.setCerts(""); config.Certs != nil {
+		t.Errorf("Did not expect certificates to be loaded from files with acme")
+	}
+
+	caFile, _ := writeTestPair(t, t.TempDir(), "ca", "ca.example.org")
+	config = NewConfig()
+	config.setHTTP(false, "", "")
+	if config.setACME(true, "", "", "", caFile); config.ACMEDirectory != DefaultACMEDirectory || config.ACMECache != DefaultACMECache || config.ACMERoots == nil {
+		t.Errorf("Expected the defaults and the ca to be used, got [%+v]", config)
+	}
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", notPEM, err)
+	}
+	testData := []struct {
+		directory string
+		caFile    string
+	}{
+		{"localhost:14000/dir", ""},
+		{"ftp://localhost/dir", ""},
+		{"https:///dir", ""},
+		{"https://localhost:14000/dir", filepath.Join(t.TempDir(), "missing.pem")},
+		{"https://localhost:14000/dir", notPEM},
+	}
+	for index, test := range testData {
+		exitCode := -1
+		config := NewConfig()
+		config.setHTTP(false, "", "")
+		config.exitFunc = func(code int) {
+			exitCode = code
+		}
+		if config.setACME(true, test.directory, "", "", test.caFile); exitCode != errors.ExitCodeBadACME || config.UseACME {
+			t.Errorf("Expected testData[%d] to exit with [%v], got [%v]", index, errors.ExitCodeBadACME, exitCode)
+		}
+	}
+
+	plain := NewConfig()
+	plain.setHTTP(true, "", "")
+	if plain.setACME(true, "", "", "", ""); plain.UseACME {
+		t.Errorf("Did not expect acme in plain http")
+	}
+}
+
+func Test_HostPolicy(t *testing.T) {
+	mappingPath := writeMapping(t, map[string][2]string{"shop.example.org": {}, "*.legacy.example.org": {}})
+	config := newACMEConfig(t, mappingPath)
+	fastServer := NewFastServer(config, config.MappingsFile)
+
+	testData := map[string]bool{
+		"shop.example.org":     true,
+		"Shop.Example.org":     true,
+		"shop.example.org:80":  true,
+		"a.legacy.example.org": false, // wildcards need dns-01
+		"*.legacy.example.org": false,
+		"unknown.example.org":  false,
+		"localhost":            false,
+	}
+	for host, allowed := range testData {
+		if err := fastServer.hostPolicy(context.Background(), host); (err == nil) != allowed {
+			t.Errorf("Expected host [%s] allowed to be [%v], got error: %v", host, allowed, err)
+		}
+	}
+
+	// hosts added by a reload are allowed straight away
+	reloaded, err := mapping.LoadMappingFile(writeMapping(t, map[string][2]string{"blog.example.org": {}}))
+	if err != nil {
+		t.Fatalf("Test harness could not load the mapping file: %v", err)
+	}
+	fastServer.swapMappingFile(reloaded)
+	if err := fastServer.hostPolicy(context.Background(), "blog.example.org"); err != nil {
+		t.Errorf("Expected a reloaded host to be allowed, got error: %v", err)
+	}
+	if err := fastServer.hostPolicy(context.Background(), "shop.example.org"); err == nil {
+		t.Errorf("Expected a host no longer mapped to be refused")
+	}
+}
+
+func Test_ACMEChallenge(t *testing.T) {
+	mappingPath := writeMapping(t, map[string][2]string{"shop.example.org": {}})
+	config := newACMEConfig(t, mappingPath)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	server := fastServer.setup()
+
+	// the token the acme manager was handed for the challenge
+	token := filepath.Join(config.ACMECache, "token+http-01")
+	if err := ioutil.WriteFile(token, []byte("token.thumbprint"), 0600); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", token, err)
+	}
+
+	testData := []struct {
+		host   string
+		target string
+		status int
+		body   string
+	}{
+		{"shop.example.org", "/.well-known/acme-challenge/token", 200, "token.thumbprint"},
+		{"shop.example.org", "/.well-known/acme-challenge/unknown", 404, ""},
+		{"unknown.example.org", "/.well-known/acme-challenge/token", 403, ""},
+	}
+	for _, test := range testData {
+		request := httptest.NewRequest("GET", test.target, nil)
+		request.Host = test.host
+		resp, err := server.Test(request)
+		if err != nil {
+			t.Fatalf("Did not expect to get an error testing target [%s], error: %v", test.target, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != test.status || (test.body != "" && string(body) != test.body) {
+			t.Errorf("Expected [%d] [%s] for [%s%s], got [%d] [%s]", test.status, test.body, test.host, test.target, resp.StatusCode, body)
+		}
+	}
+
+	// tls-alpn-01 challenges are negotiated on the tls listener
+	protos := fastServer.acmeTLSConfig().NextProtos
+	if len(protos) != 2 || protos[1] != acme.ALPNProto {
+		t.Errorf("Expected [%s] to be offered, got %v", acme.ALPNProto, protos)
+	}
+
+	_, status := probe(t, fastServer, "/livez")
+	if status.TLS == nil || status.TLS.ACME != config.ACMEDirectory || status.TLS.Cert != "" {
+		t.Errorf("Expected the acme directory to be reported, got [%+v]", status.TLS)
+	}
+}
+
+// standInOrder is an order placed with the acme stand-in, for a single domain
+type standInOrder struct {
+	domain string
+	valid  bool   // the http-01 challenge was answered
+	leaf   []byte // issued certificate, nil until the order is finalized
+}
+
+/*
+*
+acmeStandIn is an in-process acme directory issuing certificates once the http-01 challenge of an
+order is answered by `fastServer`, the way a real directory fetches the token from the mapped host.
+*/
+type acmeStandIn struct {
+	t          *testing.T
+	server     *httptest.Server
+	fastServer *FastServer
+	caKey      *ecdsa.PrivateKey
+	ca         *x509.Certificate
+
+	mu     sync.Mutex
+	orders []*standInOrder
+}
+
+// newACMEStandIn starts an acme stand-in, writing the CA its tls certificate is trusted with to `caFile`
+func newACMEStandIn(t *testing.T) (*acmeStandIn, string) {
+	standIn := &acmeStandIn{t: t}
+	standIn.server = httptest.NewTLSServer(http.HandlerFunc(standIn.handle))
+	t.Cleanup(standIn.server.Close)
+
+	caDir := t.TempDir()
+	caFile, caKey := writeTestPair(t, caDir, "ca", "ca.example.org")
+	ca, err := tls.LoadX509KeyPair(caFile, caKey)
+	if err != nil {
+		t.Fatalf("Test harness could not load the ca: %v", err)
+	}
+	standIn.ca, _ = x509.ParseCertificate(ca.Certificate[0])
+	standIn.caKey = ca.PrivateKey.(*ecdsa.PrivateKey)
+
+	trusted := filepath.Join(caDir, "directory.pem")
+	if err := ioutil.WriteFile(trusted, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: standIn.server.Certificate().Raw}), 0644); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", trusted, err)
+	}
+
+	return standIn, trusted
+}
+
+// ordered returns the domains orders were placed for
+func (s *acmeStandIn) ordered() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var domains []string
+	for _, order := range s.orders {
+		domains = append(domains, order.domain)
+	}
+	return domains
+}
+
+// payload decodes the payload of a jws request, the signature is not checked
+func (s *acmeStandIn) payload(r *http.Request, v interface{}) {
+	var jws struct{ Payload string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		s.t.Errorf("Expected a jws request to [%s], error: %v", r.URL.Path, err)
+		return
+	}
+	data, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, v); err != nil {
+			s.t.Errorf("Expected a json payload to [%s], error: %v", r.URL.Path, err)
+		}
+	}
+}
+
+// orderJSON describes the order at `index`, the caller holds the lock
+func (s *acmeStandIn) orderJSON(index int) map[string]interface{} {
+	order := s.orders[index]
+	status := acme.StatusPending
+	if order.leaf != nil {
+		status = acme.StatusValid
+	} else if order.valid {
+		status = acme.StatusReady
+	}
+	body := map[string]interface{}{
+		"status":         status,
+		"identifiers":    []map[string]string{{"type": "dns", "value": order.domain}},
+		"authorizations": []string{fmt.Sprintf("%s/authz/%d", s.server.URL, index)},
+		"finalize":       fmt.Sprintf("%s/finalize/%d", s.server.URL, index),
+	}
+	if order.leaf != nil {
+		body["certificate"] = fmt.Sprintf("%s/cert/%d", s.server.URL, index)
+	}
+	return body
+}
+
+// challengeJSON describes the http-01 challenge of the order at `index`, the caller holds the lock
+func (s *acmeStandIn) challengeJSON(index int) map[string]interface{} {
+	status := acme.StatusPending
+	if s.orders[index].valid {
+		status = acme.StatusValid
+	}
+	return map[string]interface{}{
+		"type":   "http-01",
+		"url":    fmt.Sprintf("%s/challenge/%d", s.server.URL, index),
+		"token":  fmt.Sprintf("token-%d", index),
+		"status": status,
+	}
+}
+
+// validate fetches the token of the order at `index` from the mapped host, as a directory would
+func (s *acmeStandIn) validate(index int, domain string) bool {
+	token := fmt.Sprintf("token-%d", index)
+	request := httptest.NewRequest("GET", ACMEChallengeRoute+token, nil)
+	request.Host = domain
+	resp, err := s.fastServer.server.Test(request)
+	if err != nil {
+		s.t.Errorf("Did not expect an error fetching the challenge of [%s], error: %v", domain, err)
+		return false
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode == 200 && strings.HasPrefix(string(body), token+".")
+}
+
+// issue signs a certificate for the names of a csr
+func (s *acmeStandIn) issue(csr *x509.CertificateRequest) ([]byte, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return x509.CreateCertificate(rand.Reader, template, s.ca, csr.PublicKey, s.caKey)
+}
+
+func (s *acmeStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	w.Header().Set("Content-Type", "application/json")
+	reply := func(status int, body interface{}) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	index := -1
+	if len(parts) == 2 {
+		index, _ = strconv.Atoi(parts[1])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(parts) == 2 && (index < 0 || index >= len(s.orders)) {
+		reply(404, map[string]string{"type": "urn:ietf:params:acme:error:malformed"})
+		return
+	}
+
+	switch parts[0] {
+	case "dir":
+		reply(200, map[string]string{
+			"newNonce":   s.server.URL + "/nonce",
+			"newAccount": s.server.URL + "/account",
+			"newOrder":   s.server.URL + "/order",
+			"revokeCert": s.server.URL + "/revoke",
+			"keyChange":  s.server.URL + "/key-change",
+		})
+	case "nonce":
+		w.WriteHeader(200)
+	case "account":
+		w.Header().Set("Location", s.server.URL+"/account/1")
+		reply(201, map[string]string{"status": acme.StatusValid})
+	case "order":
+		if index >= 0 {
+			w.Header().Set("Location", r.URL.String())
+			reply(200, s.orderJSON(index))
+			return
+		}
+		var req struct {
+			Identifiers []struct{ Value string }
+		}
+		s.payload(r, &req)
+		s.orders = append(s.orders, &standInOrder{domain: req.Identifiers[0].Value})
+		index = len(s.orders) - 1
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", s.server.URL, index))
+		reply(201, s.orderJSON(index))
+	case "authz":
+		status := acme.StatusPending
+		if s.orders[index].valid {
+			status = acme.StatusValid
+		}
+		reply(200, map[string]interface{}{
+			"status":     status,
+			"identifier": map[string]string{"type": "dns", "value": s.orders[index].domain},
+			"challenges": []interface{}{s.challengeJSON(index)},
+		})
+	case "challenge":
+		// validated straight away, the manager already handed the token over
+		s.orders[index].valid = s.validate(index, s.orders[index].domain)
+		reply(200, s.challengeJSON(index))
+	case "finalize":
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		s.payload(r, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || !s.orders[index].valid || len(csr.DNSNames) != 1 || csr.DNSNames[0] != s.orders[index].domain {
+			reply(403, map[string]string{"type": "urn:ietf:params:acme:error:unauthorized"})
+			return
+		}
+		if s.orders[index].leaf, err = s.issue(csr); err != nil {
+			s.t.Errorf("Test harness could not issue a certificate: %v", err)
+		}
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%d", s.server.URL, index))
+		reply(200, s.orderJSON(index))
+	case "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: s.orders[index].leaf})
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: s.ca.Raw})
+	default:
+		reply(404, map[string]string{"type": "urn:ietf:params:acme:error:malformed"})
+	}
+}
+
+// helloFor is a client hello for `host` accepting ecdsa certificates
+func helloFor(host string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:       host,
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:  []tls.CurveID{tls.CurveP256},
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+}
+
+func Test_ACMEObtainCertificate(t *testing.T) {
+	standIn, caFile := newACMEStandIn(t)
+	mappingPath := writeMapping(t, map[string][2]string{"shop.example.org": {}})
+	config := newACMEConfig(t, mappingPath)
+	config.setACME(true, standIn.server.URL+"/dir", t.TempDir(), "ops@example.org", caFile)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+	standIn.fastServer = fastServer
+
+	// the mapped host answers the challenge and is issued a certificate
+	cert, err := fastServer.acmeTLSConfig().GetCertificate(helloFor("shop.example.org"))
+	if err != nil {
+		t.Fatalf("Expected a certificate for a mapped host, error: %v", err)
+	}
+	if cert.Leaf == nil || cert.Leaf.VerifyHostname("shop.example.org") != nil {
+		t.Fatalf("Expected a certificate valid for [shop.example.org], got [%+v]", cert.Leaf)
+	}
+	if ordered := standIn.ordered(); len(ordered) != 1 || ordered[0] != "shop.example.org" {
+		t.Errorf("Expected a single order for [shop.example.org], got %v", ordered)
+	}
+
+	// an unmapped host is refused before anything is ordered
+	if _, err := fastServer.acmeTLSConfig().GetCertificate(helloFor("unknown.example.org")); err == nil {
+		t.Errorf("Expected an unmapped host to be refused")
+	}
+	if ordered := standIn.ordered(); len(ordered) != 1 {
+		t.Errorf("Did not expect an order for an unmapped host, got %v", ordered)
+	}
+
+	// the certificate is cached, a restarted server serves it without ordering again
+	restarted := NewFastServer(config, config.MappingsFile)
+	restarted.setup()
+	cached, err := restarted.acmeTLSConfig().GetCertificate(helloFor("shop.example.org"))
+	if err != nil || !bytes.Equal(cached.Certificate[0], cert.Certificate[0]) {
+		t.Errorf("Expected the cached certificate to be served, got error: %v", err)
+	}
+	if ordered := standIn.ordered(); len(ordered) != 1 {
+		t.Errorf("Expected the cached certificate to be reused, got orders %v", ordered)
+	}
+
+	// its expiry is reported
+	_, status := probe(t, restarted, "/livez")
+	if status.TLS == nil || len(status.TLS.Certificates) != 1 {
+		t.Fatalf("Expected the cached certificate to be reported, got [%+v]", status.TLS)
+	}
+	info := status.TLS.Certificates[0]
+	if info.File != filepath.Join(config.ACMECache, "shop.example.org") || !info.NotAfter.Equal(cert.Leaf.NotAfter) {
+		t.Errorf("Expected [%s] expiring at [%s], got [%+v]", filepath.Join(config.ACMECache, "shop.example.org"), cert.Leaf.NotAfter, info)
+	}
+	if status.TLS.NotAfter == nil || !status.TLS.NotAfter.Equal(cert.Leaf.NotAfter) {
+		t.Errorf("Expected the expiry of the cached certificate, got [%v]", status.TLS.NotAfter)
+	}
+}
@@ -0,0 +1,232 @@
+package certstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// certExtensions are the extensions of certificate files in a directory, the key has the same name ending in `.key`
+var certExtensions = []string{".pem", ".crt"}
+
+// Pair is the files of a certificate and its key
+type Pair struct {
+	Cert string
+	Key  string
+}
+
+// Info describes a loaded certificate
+type Info struct {
+	File     string    `json:"file"`
+	Names    []string  `json:"names"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+/*
+*
+Store selects the certificate for a tls handshake by the server name the client sent (SNI).
+Certificates are found by exact name, then by wildcard, falling back to the default certificate.
+*/
+type Store struct {
+	byName   map[string]*tls.Certificate // lower case dns names, wildcards included
+	fallback *tls.Certificate
+	infos    []Info // the fallback first, then in the order loaded
+	LoadedAt time.Time
+}
+
+// normalize lower cases a name and drops the trailing dot of a fully qualified name
+func normalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// loadPair loads a certificate and its key, parsing the leaf so its names and expiry can be read
+func loadPair(pair Pair) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
+	if err != nil {
+		return nil, fmt.Errorf("could not load certificate [%s] with key [%s]: %v", pair.Cert, pair.Key, err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("could not parse certificate [%s]: %v", pair.Cert, err)
+	}
+
+	return &cert, nil
+}
+
+// names returns the dns names of a certificate, its common name when it has none
+func names(cert *tls.Certificate) []string {
+	if len(cert.Leaf.DNSNames) > 0 {
+		return cert.Leaf.DNSNames
+	}
+	if cert.Leaf.Subject.CommonName != "" {
+		return []string{cert.Leaf.Subject.CommonName}
+	}
+
+	return nil
+}
+
+// DirPairs lists the certificate and key pairs of a directory, a `name.pem` or `name.crt` certificate with its `name.key`
+func DirPairs(dir string) ([]Pair, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pairs []Pair
+	for _, file := range files {
+		extension := filepath.Ext(file.Name())
+		if file.IsDir() || !contains(certExtensions, extension) {
+			continue
+		}
+		pairs = append(pairs, Pair{
+			Cert: filepath.Join(dir, file.Name()),
+			Key:  filepath.Join(dir, strings.TrimSuffix(file.Name(), extension)+".key"),
+		})
+	}
+
+	return pairs, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+*
+Load is a factory which creates a Store. The `fallback` pair answers handshakes no other certificate
+matches. Every pair of `dir` is found by the names it was issued for, see DirPairs, and the pairs of
+`hosts` by the host key they are set for, winning over the directory.
+*/
+func Load(fallback Pair, dir string, hosts map[string]Pair) (*Store, error) {
+	store := &Store{byName: map[string]*tls.Certificate{}, LoadedAt: time.Now()}
+
+	var err error
+	if store.fallback, err = store.load(fallback); err != nil {
+		return nil, err
+	}
+
+	if dir != "" {
+		pairs, err := DirPairs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("could not read certificate directory [%s]: %v", dir, err)
+		}
+		for _, pair := range pairs {
+			cert, err := store.load(pair)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names(cert) {
+				store.byName[normalize(name)] = cert
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(hosts))
+	for host := range hosts {
+		keys = append(keys, host)
+	}
+	sort.Strings(keys)
+	for _, host := range keys {
+		cert, err := store.load(hosts[host])
+		if err != nil {
+			return nil, fmt.Errorf("host [%s]: %v", host, err)
+		}
+		store.byName[normalize(host)] = cert
+	}
+
+	return store, nil
+}
+
+// load loads a pair, recording what was loaded
+func (s *Store) load(pair Pair) (*tls.Certificate, error) {
+	cert, err := loadPair(pair)
+	if err != nil {
+		return nil, err
+	}
+	s.infos = append(s.infos, Info{File: pair.Cert, Names: names(cert), NotAfter: cert.Leaf.NotAfter})
+
+	return cert, nil
+}
+
+// Infos describes every certificate of the store, the fallback first
+func (s *Store) Infos() []Info {
+	return append([]Info(nil), s.infos...)
+}
+
+// lookup finds the certificate for a server name by exact name then by wildcard, nil when none matches
+func (s *Store) lookup(serverName string) *tls.Certificate {
+	name := normalize(serverName)
+	if cert, ok := s.byName[name]; ok {
+		return cert
+	}
+	if dot := strings.Index(name, "."); dot > 0 {
+		if cert, ok := s.byName["*"+name[dot:]]; ok {
+			return cert
+		}
+	}
+
+	return nil
+}
+
+// GetCertificate returns the certificate for a handshake, see tls.Config
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := s.lookup(hello.ServerName); cert != nil {
+		return cert, nil
+	}
+
+	return s.fallback, nil
+}
+
+// validFor reports whether a certificate is valid for a host key, a wildcard host key needs a certificate issued for the same wildcard
+func validFor(cert *tls.Certificate, host string) bool {
+	if strings.HasPrefix(host, "*.") {
+		for _, name := range names(cert) {
+			if normalize(name) == host {
+				return true
+			}
+		}
+		return false
+	}
+
+	return cert.Leaf.VerifyHostname(host) == nil
+}
+
+/*
+*
+Covers reports whether the certificate a handshake for a host key gets is valid for it. A wildcard
+host key needs a certificate issued for the same wildcard, which only covers names one label below
+it, an exact host is also covered by a wildcard certificate. A pair set for a host which was not
+issued for it leaves the host uncovered.
+*/
+func (s *Store) Covers(host string) bool {
+	host = normalize(host)
+	cert := s.lookup(host)
+	if cert == nil {
+		cert = s.fallback
+	}
+
+	return validFor(cert, host)
+}
+
+// Uncovered returns the hosts not served a certificate valid for them, sorted
+func (s *Store) Uncovered(hosts []string) []string {
+	var uncovered []string
+	for _, host := range hosts {
+		if !s.Covers(host) {
+			uncovered = append(uncovered, host)
+		}
+	}
+	sort.Strings(uncovered)
+
+	return uncovered
+}
@@ -0,0 +1,181 @@
+package certstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writePair writes a self signed certificate for `names` and its key to `dir`, as `file.pem` and `file.key`
+func writePair(t *testing.T, dir string, file string, names ...string) Pair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Test harness could not generate a key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Test harness could not create a certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Test harness could not marshal a key: %v", err)
+	}
+
+	pair := Pair{Cert: filepath.Join(dir, file+".pem"), Key: filepath.Join(dir, file+".key")}
+	if err := ioutil.WriteFile(pair.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", pair.Cert, err)
+	}
+	if err := ioutil.WriteFile(pair.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", pair.Key, err)
+	}
+
+	return pair
+}
+
+func Test_DirPairs(t *testing.T) {
+	dir := t.TempDir()
+	writePair(t, dir, "one", "one.example.org")
+	writePair(t, dir, "two", "two.example.org")
+	if err := os.Rename(filepath.Join(dir, "two.pem"), filepath.Join(dir, "two.crt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested.pem"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pairs, err := DirPairs(dir)
+	if err != nil {
+		t.Fatalf("Did not expect an error listing pairs, error: %v", err)
+	}
+	expected := []Pair{
+		{Cert: filepath.Join(dir, "one.pem"), Key: filepath.Join(dir, "one.key")},
+		{Cert: filepath.Join(dir, "two.crt"), Key: filepath.Join(dir, "two.key")},
+	}
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("Expected pairs %v, got %v", expected, pairs)
+	}
+
+	if _, err := DirPairs(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("Expected an error for a missing directory")
+	}
+}
+
+func Test_Store(t *testing.T) {
+	dir := t.TempDir()
+	fallback := writePair(t, t.TempDir(), "server", "default.example.org")
+	writePair(t, dir, "blog", "blog.example.org", "www.blog.example.org")
+	writePair(t, dir, "wildcard", "*.legacy.example.org")
+	hosts := map[string]Pair{"Shop.Example.org": writePair(t, t.TempDir(), "shop", "shop.example.org")}
+
+	store, err := Load(fallback, dir, hosts)
+	if err != nil {
+		t.Fatalf("Did not expect an error loading the store, error: %v", err)
+	}
+
+	testData := map[string]string{
+		"blog.example.org":       "blog.example.org",
+		"WWW.Blog.example.org.":  "blog.example.org",
+		"a.legacy.example.org":   "*.legacy.example.org",
+		"shop.example.org":       "shop.example.org",
+		"a.b.legacy.example.org": "default.example.org", // wildcards only cover one label
+		"unknown.example.org":    "default.example.org",
+		"":                       "default.example.org", // no SNI
+	}
+	for serverName, expected := range testData {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		if err != nil || cert.Leaf.DNSNames[0] != expected {
+			t.Errorf("Expected [%s] for [%s], got %v with error: %v", expected, serverName, cert.Leaf.DNSNames, err)
+		}
+	}
+
+	infos := store.Infos()
+	if len(infos) != 4 || infos[0].File != fallback.Cert || infos[3].Names[0] != "shop.example.org" {
+		t.Errorf("Expected the fallback first and the host pair last, got %+v", infos)
+	}
+	for _, info := range infos {
+		if !info.NotAfter.After(time.Now()) {
+			t.Errorf("Expected [%s] to expire in the future, got [%s]", info.File, info.NotAfter)
+		}
+	}
+
+	uncovered := store.Uncovered([]string{
+		"blog.example.org",
+		"a.legacy.example.org",
+		"*.legacy.example.org",
+		"shop.example.org",
+		"default.example.org",
+		"*.blog.example.org",
+		"unknown.example.org",
+	})
+	if expected := []string{"*.blog.example.org", "unknown.example.org"}; !reflect.DeepEqual(uncovered, expected) {
+		t.Errorf("Expected %v uncovered, got %v", expected, uncovered)
+	}
+}
+
+func Test_CoversHostPairs(t *testing.T) {
+	dir := t.TempDir()
+	fallback := writePair(t, t.TempDir(), "server", "default.example.org")
+	writePair(t, dir, "wildcard", "*.legacy.example.org")
+	hosts := map[string]Pair{
+		"shop.example.org":   writePair(t, t.TempDir(), "unrelated", "unrelated.example.net"),
+		"*.blog.example.org": writePair(t, t.TempDir(), "blog", "www.blog.example.org"),
+	}
+
+	store, err := Load(fallback, dir, hosts)
+	if err != nil {
+		t.Fatalf("Did not expect an error loading the store, error: %v", err)
+	}
+
+	// a pair set for a host is only valid when issued for it, wildcards only cover one label
+	uncovered := store.Uncovered([]string{"shop.example.org", "*.blog.example.org", "*.legacy.example.org", "a.b.legacy.example.org"})
+	if expected := []string{"*.blog.example.org", "a.b.legacy.example.org", "shop.example.org"}; !reflect.DeepEqual(uncovered, expected) {
+		t.Errorf("Expected %v uncovered, got %v", expected, uncovered)
+	}
+}
+
+func Test_LoadErrors(t *testing.T) {
+	fallback := writePair(t, t.TempDir(), "server", "default.example.org")
+	mismatched := writePair(t, t.TempDir(), "other", "other.example.org")
+	mismatched.Key = fallback.Key
+
+	dirWithoutKey := t.TempDir()
+	writePair(t, dirWithoutKey, "lonely", "lonely.example.org")
+	if err := os.Remove(filepath.Join(dirWithoutKey, "lonely.key")); err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		fallback Pair
+		dir      string
+		hosts    map[string]Pair
+	}{
+		{Pair{Cert: "missing.pem", Key: "missing.key"}, "", nil},
+		{mismatched, "", nil},
+		{fallback, filepath.Join(t.TempDir(), "missing"), nil},
+		{fallback, dirWithoutKey, nil},
+		{fallback, "", map[string]Pair{"other.example.org": mismatched}},
+	}
+	for index, test := range testData {
+		if _, err := Load(test.fallback, test.dir, test.hosts); err == nil {
+			t.Errorf("Expected testData[%d] to fail loading", index)
+		}
+	}
+}
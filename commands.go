@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-redirector/errors"
+	"go-redirector/mapping"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli"
+)
+
+/*
+*
+Validate each mapping file, writing the outcome of every file to `out`. With `runTests` the tests of
+each valid file are run as well. Returns the number of files which failed validation and the number
+of valid files whose tests failed, every file is checked even after a failure.
+*/
+func validateMappingFiles(files []string, runTests bool, out io.Writer) (int, int) {
+	invalid, failing := 0, 0
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		var mappingFile *mapping.MappingsFile
+		if err == nil {
+			mappingFile, err = mapping.Parse(data)
+		}
+
+		if validationErr, ok := err.(*mapping.ValidationError); ok {
+			invalid++
+			fmt.Fprintf(out, "%s: FAILED with %d problem(s)\n", file, len(validationErr.Problems))
+			for _, problem := range validationErr.Problems {
+				fmt.Fprintf(out, "  %s\n", problem)
+			}
+			continue
+		} else if err != nil {
+			invalid++
+			fmt.Fprintf(out, "%s: FAILED\n  %v\n", file, err)
+			continue
+		}
+
+		if !runTests {
+			fmt.Fprintf(out, "%s: OK\n", file)
+			continue
+		}
+
+		failures := runExpectations(mappingFile)
+		if len(failures) > 0 {
+			failing++
+			fmt.Fprintf(out, "%s: FAILED %d of %d test(s)\n", file, len(failures), len(mappingFile.Tests))
+			for _, failure := range failures {
+				fmt.Fprintf(out, "  %s\n", failure)
+			}
+			continue
+		}
+		fmt.Fprintf(out, "%s: OK, %d test(s) passed\n", file, len(mappingFile.Tests))
+	}
+
+	return invalid, failing
+}
+
+// checkMappingFiles validates, and with `runTests` tests, the files given as arguments or else the `file` flag
+func checkMappingFiles(c *cli.Context, runTests bool) error {
+	config := NewConfig()
+	config.setLogLevel(c.String("log-level"))
+
+	files := []string(c.Args())
+	if len(files) == 0 {
+		files = []string{c.String("file")}
+	}
+
+	invalid, failing := validateMappingFiles(files, runTests, c.App.Writer)
+	if invalid > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d of %d mapping file(s) failed validation", invalid, len(files)), errors.ExitCodeBadMappingFile)
+	}
+	if failing > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d of %d mapping file(s) failed their tests", failing, len(files)), errors.ExitCodeMappingTestsFailed)
+	}
+
+	return nil
+}
+
+func validateAction(c *cli.Context) error {
+	return checkMappingFiles(c, c.Bool("test"))
+}
+
+func testAction(c *cli.Context) error {
+	return checkMappingFiles(c, true)
+}
+
+// resolvedURL is the outcome of resolving a single url for the resolve command
+type resolvedURL struct {
+	URL   string `json:"url"`
+	Found bool   `json:"found"`
+	*Resolution
+}
+
+// parseRequestURL splits a url into the host, path and query the server would see, assuming https without a scheme
+func parseRequestURL(rawURL string) (string, string, string, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	requestURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	path := requestURL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	return requestURL.Hostname(), path, requestURL.RawQuery, nil
+}
+
+/*
+*
+Resolve each url against the mappings file and write where it goes to `out`, as text or one
+json object per line. Returns the number of urls which did not resolve.
+*/
+func resolveURLs(mappingFile *mapping.MappingsFile, urls []string, asJSON bool, out io.Writer) (int, error) {
+	missed := 0
+	encoder := json.NewEncoder(out)
+
+	for _, rawURL := range urls {
+		host, path, query, err := parseRequestURL(rawURL)
+		if err != nil {
+			return missed, fmt.Errorf("could not parse url [%s]: %v", rawURL, err)
+		}
+
+		resolved := resolvedURL{URL: rawURL}
+		if resolution, err := resolveRequest(log.Logger, mappingFile, host, path, query); err == nil {
+			resolved.Found = true
+			resolved.Resolution = resolution
+		} else {
+			missed++
+		}
+
+		if asJSON {
+			if err := encoder.Encode(resolved); err != nil {
+				return missed, err
+			}
+			continue
+		}
+
+		if !resolved.Found {
+			fmt.Fprintf(out, "%s\n  not found, responds with 404\n", rawURL)
+			continue
+		}
+		fmt.Fprintf(out, "%s\n  entry:  %s %s\n  mode:   %s\n  status: %d\n  target: %s\n",
+			rawURL, resolved.Host, resolved.Path, resolved.Mode, resolved.Status, resolved.Target)
+	}
+
+	return missed, nil
+}
+
+func resolveAction(c *cli.Context) error {
+	config := NewConfig()
+	config.setLogLevel(c.String("log-level"))
+
+	urls := []string(c.Args())
+	if len(urls) == 0 {
+		return cli.NewExitError("resolve needs at least one url", errors.ExitCodeConfigError)
+	}
+
+	mappingFile, err := mapping.LoadMappingFile(c.String("file"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Bad mapping file: %v", err), errors.ExitCodeBadMappingFile)
+	}
+
+	missed, err := resolveURLs(mappingFile, urls, c.Bool("json"), c.App.Writer)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errors.ExitCodeExecutionFailure)
+	}
+	if missed > 0 {
+		return cli.NewExitError("", errors.ExitCodeExecutionFailure)
+	}
+
+	return nil
+}
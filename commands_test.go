@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"go-redirector/errors"
+	"go-redirector/mapping"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func newCommandContext(t *testing.T, args []string, flags ...cli.Flag) *cli.Context {
+	flagSet := flag.NewFlagSet("test", 0)
+	for _, fl := range flags {
+		fl.Apply(flagSet)
+	}
+	if err := flagSet.Parse(args); err != nil {
+		t.Fatalf("Test harness could not parse args %v: %v", args, err)
+	}
+
+	app := cli.NewApp()
+	app.Writer = &bytes.Buffer{}
+	return cli.NewContext(app, flagSet, nil)
+}
+
+func Test_ValidateMappingFiles(t *testing.T) {
+	var out bytes.Buffer
+	files := []string{
+		"./tests/test-redirect-map.yml",
+		"./tests/bad-redirect-map.yml",
+		"./tests/noop.yml",
+	}
+
+	if invalid, failing := validateMappingFiles(files, false, &out); invalid != 2 || failing != 0 {
+		t.Errorf("Expected [2] files to fail validation, got [%d] and [%d] failing tests:\n%s", invalid, failing, out.String())
+	}
+
+	for _, expected := range []string{
+		"./tests/test-redirect-map.yml: OK",
+		"./tests/bad-redirect-map.yml: FAILED",
+		"./tests/noop.yml: FAILED",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected to find [%s] in the output, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func Test_ValidateAction(t *testing.T) {
+	flags := getAppCommands()[1].Flags
+
+	context := newCommandContext(t, []string{"./tests/test-redirect-map.yml"}, flags...)
+	if err := validateAction(context); err != nil {
+		t.Errorf("Did not expect a valid file to fail, error: %v", err)
+	}
+
+	context = newCommandContext(t, []string{"./tests/test-redirect-map.yml", "./tests/bad-redirect-map.yml"}, flags...)
+	err := validateAction(context)
+	if exitErr, ok := err.(cli.ExitCoder); !ok {
+		t.Errorf("Expected an exit error for a bad file, got: %v", err)
+	} else if exitErr.ExitCode() != errors.ExitCodeBadMappingFile {
+		t.Errorf("Expected exit code of [%v], got [%v]", errors.ExitCodeBadMappingFile, exitErr.ExitCode())
+	}
+}
+
+func Test_ValidateMappingFilesTests(t *testing.T) {
+	var out bytes.Buffer
+	files := []string{
+		"./tests/tested-redirect-map.yml",
+		"./tests/failing-tests-redirect-map.yml",
+		"./tests/bad-redirect-map.yml",
+	}
+
+	if invalid, failing := validateMappingFiles(files, true, &out); invalid != 1 || failing != 1 {
+		t.Errorf("Expected [1] invalid file and [1] failing tests, got [%d] and [%d]:\n%s", invalid, failing, out.String())
+	}
+
+	for _, expected := range []string{
+		"./tests/tested-redirect-map.yml: OK, 4 test(s) passed",
+		"./tests/failing-tests-redirect-map.yml: FAILED 3 of 3 test(s)",
+		"  https://testedhost/about: expected a match, got not found (404)",
+		"./tests/bad-redirect-map.yml: FAILED with",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected to find [%s] in the output, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func Test_TestAction(t *testing.T) {
+	flags := getAppCommands()[2].Flags
+
+	context := newCommandContext(t, []string{"./tests/tested-redirect-map.yml"}, flags...)
+	if err := testAction(context); err != nil {
+		t.Errorf("Did not expect passing tests to fail, error: %v", err)
+	}
+
+	context = newCommandContext(t, []string{"./tests/failing-tests-redirect-map.yml"}, flags...)
+	err := testAction(context)
+	if exitErr, ok := err.(cli.ExitCoder); !ok {
+		t.Errorf("Expected an exit error for failing tests, got: %v", err)
+	} else if exitErr.ExitCode() != errors.ExitCodeMappingTestsFailed {
+		t.Errorf("Expected exit code of [%v], got [%v]", errors.ExitCodeMappingTestsFailed, exitErr.ExitCode())
+	}
+
+	// validate only runs the tests when asked
+	context = newCommandContext(t, []string{"./tests/failing-tests-redirect-map.yml"}, getAppCommands()[1].Flags...)
+	if err := validateAction(context); err != nil {
+		t.Errorf("Did not expect validate to run the tests, error: %v", err)
+	}
+	context = newCommandContext(t, []string{"--test", "./tests/failing-tests-redirect-map.yml"}, getAppCommands()[1].Flags...)
+	if err := validateAction(context); err == nil {
+		t.Errorf("Expected validate --test to fail on failing tests")
+	}
+}
+
+func Test_ParseRequestURL(t *testing.T) {
+	testData := []struct {
+		url           string
+		expectedHost  string
+		expectedPath  string
+		expectedQuery string
+	}{
+		{"https://old.example.org/foo?x=1", "old.example.org", "/foo", "x=1"},
+		{"old.example.org:8443/foo", "old.example.org", "/foo", ""},
+		{"http://old.example.org", "old.example.org", "/", ""},
+	}
+
+	for _, testEntry := range testData {
+		host, path, query, err := parseRequestURL(testEntry.url)
+		if err != nil {
+			t.Errorf("Did not expect an error parsing [%s]: %v", testEntry.url, err)
+			continue
+		}
+		if host != testEntry.expectedHost || path != testEntry.expectedPath || query != testEntry.expectedQuery {
+			t.Errorf("Expected [%s] to parse into [%s] [%s] [%s], got [%s] [%s] [%s]", testEntry.url,
+				testEntry.expectedHost, testEntry.expectedPath, testEntry.expectedQuery, host, path, query)
+		}
+	}
+}
+
+func Test_ResolveURLs(t *testing.T) {
+	mappingFile, err := mapping.LoadMappingFile("./tests/path-redirect-map.yml")
+	if err != nil {
+		t.Fatalf("Could not load test mapping file: %v", err)
+	}
+
+	var out bytes.Buffer
+	urls := []string{"https://pathhost/docs/guide?page=2", "https://pathhost/missing"}
+	missed, err := resolveURLs(mappingFile, urls, false, &out)
+	if err != nil {
+		t.Fatalf("Did not expect an error resolving urls: %v", err)
+	}
+	if missed != 1 {
+		t.Errorf("Expected [1] url not to resolve, got [%d]", missed)
+	}
+
+	for _, expected := range []string{
+		"entry:  pathhost /docs/*",
+		"mode:   immediate",
+		"status: 302",
+		"target: https://localhost:8081/manual/guide?page=2",
+		"not found",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected to find [%s] in the output, got:\n%s", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	if _, err := resolveURLs(mappingFile, urls[:1], true, &out); err != nil {
+		t.Fatalf("Did not expect an error resolving urls: %v", err)
+	}
+	var resolved map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &resolved); err != nil {
+		t.Fatalf("Expected json output, got [%s]: %v", out.String(), err)
+	}
+	if resolved["target"] != "https://localhost:8081/manual/guide?page=2" || resolved["mode"] != ModeImmediate {
+		t.Errorf("Unexpected json output [%s]", out.String())
+	}
+}
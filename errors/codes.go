@@ -23,4 +23,20 @@ const (
 	ExitCodeInvalidLoglevel
 	// ExitMetricsIssue defines an error when there is an issue with the Metrics endpoint
 	ExitMetricsIssue
+	// ExitCodeMappingTestsFailed defines an error when the tests of a mapping file do not pass
+	ExitCodeMappingTestsFailed
+	// ExitCodeShutdownTimeout defines an error when requests are still in flight once the shutdown timeout passes
+	ExitCodeShutdownTimeout
+	// ExitCodeBadProxyConfig defines an error when the trusted proxies or proxy header are invalid
+	ExitCodeBadProxyConfig
+	// ExitCodeBadAccessLog defines an error when the access log format is unknown or its file cannot be opened
+	ExitCodeBadAccessLog
+	// ExitCodeBadRequestIDHeader defines an error when the request id header is not a valid header name
+	ExitCodeBadRequestIDHeader
+	// ExitCodeBadCert defines an error when a certificate or its key cannot be loaded
+	ExitCodeBadCert
+	// ExitCodeUncoveredHosts defines an error when a mapped host is not served a certificate valid for it
+	ExitCodeUncoveredHosts
+	// ExitCodeBadACME defines an error when the acme directory url or its ca cannot be used
+	ExitCodeBadACME
 )
@@ -16,6 +16,14 @@ func Test_ErrorsType(t *testing.T) {
 		ExitCodeBadMappingFile,
 		ExitCodeInvalidLoglevel,
 		ExitMetricsIssue,
+		ExitCodeMappingTestsFailed,
+		ExitCodeShutdownTimeout,
+		ExitCodeBadProxyConfig,
+		ExitCodeBadAccessLog,
+		ExitCodeBadRequestIDHeader,
+		ExitCodeBadCert,
+		ExitCodeUncoveredHosts,
+		ExitCodeBadACME,
 	}
 
 	for code := range codes {
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"go-redirector/mapping"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+/*
+*
+Check a single expectation against the mappings file, resolving its url the way the server
+answers it. Returns why the expectation failed, or an empty string when it passed.
+*/
+func checkExpectation(mappingFile *mapping.MappingsFile, expectation *mapping.Expectation) string {
+	host, path, query, err := parseRequestURL(expectation.URL)
+	if err != nil {
+		return fmt.Sprintf("could not parse url: %v", err)
+	}
+
+	resolution, err := resolveRequest(log.Logger, mappingFile, host, path, query)
+	if err != nil {
+		if expectation.Status == 404 {
+			return ""
+		}
+		return "expected a match, got not found (404)"
+	}
+
+	var reasons []string
+	if expectation.Status == 404 {
+		reasons = append(reasons, fmt.Sprintf("expected not found (404), got entry [%s %s]", resolution.Host, resolution.Path))
+	}
+	if expectation.Status != 0 && expectation.Status != 404 && expectation.Status != resolution.Status {
+		reasons = append(reasons, fmt.Sprintf("expected status [%d], got [%d]", expectation.Status, resolution.Status))
+	}
+	if expectation.Mode != "" && expectation.Mode != resolution.Mode {
+		reasons = append(reasons, fmt.Sprintf("expected mode [%s], got [%s]", expectation.Mode, resolution.Mode))
+	}
+	if expectation.Target != "" && expectation.Target != resolution.Target {
+		reasons = append(reasons, fmt.Sprintf("expected target [%s], got [%s]", expectation.Target, resolution.Target))
+	}
+
+	return strings.Join(reasons, ", ")
+}
+
+// runExpectations checks every test of the mappings file, returning a line for each which failed
+func runExpectations(mappingFile *mapping.MappingsFile) []string {
+	var failures []string
+	for i := range mappingFile.Tests {
+		expectation := &mappingFile.Tests[i]
+		if reason := checkExpectation(mappingFile, expectation); reason != "" {
+			failures = append(failures, fmt.Sprintf("%s: %s", expectation.URL, reason))
+		}
+	}
+
+	return failures
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"go-redirector/mapping"
+	"strings"
+	"testing"
+)
+
+func Test_CheckExpectation(t *testing.T) {
+	mappingFile, err := mapping.LoadMappingFile("./tests/tested-redirect-map.yml")
+	if err != nil {
+		t.Fatalf("Test harness could not load the mapping file: %v", err)
+	}
+
+	testData := []struct {
+		expectation mapping.Expectation
+		reason      string // part of the expected failure, empty when it must pass
+	}{
+		{mapping.Expectation{URL: "https://testedhost/docs/install", Target: "https://localhost:8081/manual/install"}, ""},
+		{mapping.Expectation{URL: "testedhost/docs/legacy", Status: 301, Mode: "immediate"}, ""},
+		{mapping.Expectation{URL: "https://testedhost/about", Status: 200}, ""},
+		{mapping.Expectation{URL: "https://elsewhere/", Status: 404}, ""},
+		{mapping.Expectation{URL: "https://testedhost/docs/legacy", Status: 302}, "expected status [302], got [301]"},
+		{mapping.Expectation{URL: "https://testedhost/about", Mode: "immediate"}, "expected mode [immediate], got [friendly]"},
+		{mapping.Expectation{URL: "https://testedhost/docs/install", Target: "https://localhost:8081/install"}, "got [https://localhost:8081/manual/install]"},
+		{mapping.Expectation{URL: "https://testedhost/about", Status: 404}, "expected not found (404), got entry [testedhost /]"},
+		{mapping.Expectation{URL: "https://elsewhere/", Target: "https://localhost:8082"}, "got not found (404)"},
+	}
+
+	for _, test := range testData {
+		reason := checkExpectation(mappingFile, &test.expectation)
+		if test.reason == "" && reason != "" {
+			t.Errorf("Expected [%+v] to pass, failed with: %s", test.expectation, reason)
+		} else if test.reason != "" && !strings.Contains(reason, test.reason) {
+			t.Errorf("Expected [%+v] to fail with [%s], got [%s]", test.expectation, test.reason, reason)
+		}
+	}
+}
+
+func Test_RunExpectations(t *testing.T) {
+	mappingFile, err := mapping.LoadMappingFile("./tests/tested-redirect-map.yml")
+	if err != nil {
+		t.Fatalf("Test harness could not load the mapping file: %v", err)
+	}
+	if failures := runExpectations(mappingFile); len(failures) != 0 {
+		t.Errorf("Expected every test to pass, got: %v", failures)
+	}
+
+	mappingFile, err = mapping.LoadMappingFile("./tests/failing-tests-redirect-map.yml")
+	if err != nil {
+		t.Fatalf("Test harness could not load the mapping file: %v", err)
+	}
+	if failures := runExpectations(mappingFile); len(failures) != 3 {
+		t.Errorf("Expected [3] tests to fail, got: %v", failures)
+	}
+}
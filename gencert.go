@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"go-redirector/errors"
+	"go-redirector/mapping"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	// DefaultCACert is the default file name for the local development CA
+	DefaultCACert = "./certs/ca.pem"
+	// DefaultCAKey is the default file name for the key of the local development CA
+	DefaultCAKey = "./certs/ca.key"
+	// DefaultCertValidity is how long generated development certificates are valid for
+	DefaultCertValidity = 365 * 24 * time.Hour
+	// caValidity is how long a generated development CA is valid for, it outlives the certificates it signs
+	caValidity = 10 * 365 * 24 * time.Hour
+)
+
+// devCert describes a development certificate to generate, signed by a local CA when CACert is set
+type devCert struct {
+	Hosts    []string // dns names and ip addresses of the certificate
+	ValidFor time.Duration
+	Cert     string
+	Key      string
+	CACert   string // reused when it exists, created otherwise, empty for a self signed certificate
+	CAKey    string
+}
+
+// certHosts returns the names a development certificate is issued for, every mapped host then localhost and `extra`
+func certHosts(mappingFile *mapping.MappingsFile, extra []string) []string {
+	seen := map[string]bool{}
+	var hosts []string
+	for _, host := range append(append(mappedHosts(mappingFile), "localhost", "127.0.0.1", "::1"), extra...) {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
+
+// certTemplate creates the template of a certificate valid for `validFor` from now
+func certTemplate(commonName string, validFor time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"go-redirector development"}},
+		NotBefore:    now.Add(-time.Hour), // tolerate clocks slightly behind
+		NotAfter:     now.Add(validFor),
+	}, nil
+}
+
+// writePEM writes a single pem block to `file`, creating its directory
+func writePEM(file string, blockType string, der []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
+
+// writeKey writes a private key as pkcs8 to `file`, readable by the owner only
+func writeKey(file string, key crypto.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	return writePEM(file, "PRIVATE KEY", der, 0600)
+}
+
+// readPEM reads the first pem block of `file`
+func readPEM(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem data found in [%s]", file)
+	}
+
+	return block.Bytes, nil
+}
+
+// loadCA loads the development CA and its key
+func loadCA(certFile string, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	der, err := readPEM(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse ca [%s]: %v", certFile, err)
+	}
+	if !ca.IsCA {
+		return nil, nil, fmt.Errorf("[%s] is not a ca certificate", certFile)
+	}
+
+	if der, err = readPEM(keyFile); err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		if key, err = x509.ParseECPrivateKey(der); err != nil {
+			return nil, nil, fmt.Errorf("could not parse ca key [%s]: %v", keyFile, err)
+		}
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("ca key [%s] cannot sign", keyFile)
+	}
+
+	return ca, signer, nil
+}
+
+// createCA creates a development CA, writing it and its key
+func createCA(certFile string, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := certTemplate("go-redirector development CA", caValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.MaxPathLenZero = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return nil, nil, err
+	}
+	if err := writeKey(keyFile, key); err != nil {
+		return nil, nil, err
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+// exists reports whether a file exists
+func exists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+/*
+*
+Generate a development certificate and its key, valid for every host of `d`. It is self signed, or
+signed by the local CA when one is set, the CA is created the first time and reused afterwards so
+it only needs to be trusted once. Reports what was written to `out`.
+*/
+func (d *devCert) generate(out io.Writer) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template, err := certTemplate(d.Hosts[0], d.ValidFor)
+	if err != nil {
+		return err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	template.BasicConstraintsValid = true
+	for _, host := range d.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	parent, signer := template, crypto.Signer(key)
+	if d.CACert != "" {
+		if exists(d.CACert) {
+			parent, signer, err = loadCA(d.CACert, d.CAKey)
+			if err == nil {
+				fmt.Fprintf(out, "Using ca [%s]\n", d.CACert)
+			}
+		} else {
+			parent, signer, err = createCA(d.CACert, d.CAKey)
+			if err == nil {
+				fmt.Fprintf(out, "Created ca [%s] with key [%s], trust it once to trust every certificate it signs\n", d.CACert, d.CAKey)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(d.Cert, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	if err := writeKey(d.Key, key); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Wrote certificate [%s] with key [%s], expires at [%s]\n", d.Cert, d.Key, template.NotAfter.Format(time.RFC3339))
+	fmt.Fprintf(out, "Valid for [%s]\n", strings.Join(d.Hosts, ", "))
+	return nil
+}
+
+func genCertAction(c *cli.Context) error {
+	config := NewConfig()
+	config.setLogLevel(c.String("log-level"))
+
+	mappingFile, err := mapping.LoadMappingFile(c.String("file"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Could not load mapping file [%s]: %v", c.String("file"), err), errors.ExitCodeBadMappingFile)
+	}
+
+	d := &devCert{
+		Hosts:    certHosts(mappingFile, c.StringSlice("host")),
+		ValidFor: c.Duration("valid-for"),
+		Cert:     c.String("cert"),
+		Key:      c.String("key"),
+	}
+	if c.Bool("ca") {
+		d.CACert, d.CAKey = c.String("ca-cert"), c.String("ca-key")
+	}
+	if d.ValidFor <= 0 {
+		return cli.NewExitError(fmt.Sprintf("Validity [%s] must be positive", d.ValidFor), errors.ExitCodeExecutionFailure)
+	}
+	if !c.Bool("force") {
+		for _, file := range []string{d.Cert, d.Key} {
+			if exists(file) {
+				return cli.NewExitError(fmt.Sprintf("[%s] already exists, use --force to replace it", file), errors.ExitCodeExecutionFailure)
+			}
+		}
+	}
+
+	if err := d.generate(c.App.Writer); err != nil {
+		return cli.NewExitError(fmt.Sprintf("Could not generate the certificate: %v", err), errors.ExitCodeBadCert)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"go-redirector/certstore"
+	"go-redirector/errors"
+	"go-redirector/mapping"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// genCertFlags returns the flags of the gen-cert command
+func genCertFlags(t *testing.T) []cli.Flag {
+	for _, command := range getAppCommands() {
+		if command.Name == "gen-cert" {
+			return command.Flags
+		}
+	}
+
+	t.Fatalf("Expected to find the gen-cert command")
+	return nil
+}
+
+// verify checks `certFile` is trusted by `roots` for every host
+func verify(t *testing.T, certFile string, roots *x509.CertPool, hosts []string) {
+	der, err := readPEM(certFile)
+	if err != nil {
+		t.Fatalf("Did not expect an error reading [%s], error: %v", certFile, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Did not expect an error parsing [%s], error: %v", certFile, err)
+	}
+
+	for _, host := range hosts {
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("Expected [%s] to be valid for [%s], error: %v", certFile, host, err)
+		}
+	}
+}
+
+func Test_CertHosts(t *testing.T) {
+	mappingFile, err := mapping.LoadMappingFile(writeMapping(t, map[string][2]string{"Shop.example.org": {}, "*.legacy.example.org": {}}))
+	if err != nil {
+		t.Fatalf("Test harness could not load the mapping file: %v", err)
+	}
+
+	hosts := certHosts(mappingFile, []string{"dev.local", "LOCALHOST", " "})
+	expected := []string{"*.legacy.example.org", "shop.example.org", "localhost", "127.0.0.1", "::1", "dev.local"}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("Expected hosts %v, got %v", expected, hosts)
+	}
+}
+
+func Test_GenCertAction(t *testing.T) {
+	mappingPath := writeMapping(t, map[string][2]string{"shop.example.org": {}, "*.legacy.example.org": {}})
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "certs", "server.pem"), filepath.Join(dir, "certs", "server.key")
+	args := []string{"--file", mappingPath, "--cert", certFile, "--key", keyFile, "--valid-for", "48h"}
+
+	if err := genCertAction(newCommandContext(t, args, genCertFlags(t)...)); err != nil {
+		t.Fatalf("Did not expect an error generating a certificate, error: %v", err)
+	}
+
+	// self signed, the certificate is its own root
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Expected a matching certificate and key, error: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(pair.Certificate[0])
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	verify(t, certFile, roots, []string{"shop.example.org", "a.legacy.example.org", "localhost"})
+	if validFor := time.Until(leaf.NotAfter); validFor < 47*time.Hour || validFor > 48*time.Hour {
+		t.Errorf("Expected the certificate to be valid for [48h], got [%s]", validFor)
+	}
+	if len(leaf.IPAddresses) != 2 {
+		t.Errorf("Expected the loopback addresses, got %v", leaf.IPAddresses)
+	}
+
+	// the server starts with it, every mapped host is covered
+	store, err := certstore.Load(certstore.Pair{Cert: certFile, Key: keyFile}, "", nil)
+	if err != nil {
+		t.Fatalf("Did not expect an error loading the certificate, error: %v", err)
+	}
+	if uncovered := store.Uncovered([]string{"shop.example.org", "*.legacy.example.org"}); len(uncovered) > 0 {
+		t.Errorf("Expected every mapped host to be covered, got %v uncovered", uncovered)
+	}
+
+	// existing files are kept unless forced
+	err = genCertAction(newCommandContext(t, args, genCertFlags(t)...))
+	if exitErr, ok := err.(*cli.ExitError); !ok || exitErr.ExitCode() != errors.ExitCodeExecutionFailure {
+		t.Errorf("Expected an existing certificate to be kept, got: %v", err)
+	}
+	if err := genCertAction(newCommandContext(t, append(args, "--force"), genCertFlags(t)...)); err != nil {
+		t.Errorf("Did not expect an error replacing a certificate, error: %v", err)
+	}
+
+	err = genCertAction(newCommandContext(t, []string{"--file", filepath.Join(dir, "missing.yml")}, genCertFlags(t)...))
+	if exitErr, ok := err.(*cli.ExitError); !ok || exitErr.ExitCode() != errors.ExitCodeBadMappingFile {
+		t.Errorf("Expected a missing mapping file to fail, got: %v", err)
+	}
+}
+
+func Test_GenCertCA(t *testing.T) {
+	mappingPath := writeMapping(t, map[string][2]string{"shop.example.org": {}})
+	dir := t.TempDir()
+	caFile, caKey := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	args := []string{
+		"--file", mappingPath, "--ca", "--ca-cert", caFile, "--ca-key", caKey, "--force",
+		"--cert", filepath.Join(dir, "server.pem"), "--key", filepath.Join(dir, "server.key"),
+	}
+
+	context := newCommandContext(t, args, genCertFlags(t)...)
+	if err := genCertAction(context); err != nil {
+		t.Fatalf("Did not expect an error generating a certificate, error: %v", err)
+	}
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		t.Fatalf("Expected the ca to be written, error: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca)
+	verify(t, filepath.Join(dir, "server.pem"), roots, []string{"shop.example.org", "localhost"})
+
+	// the ca is reused, it only needs to be trusted once
+	context = newCommandContext(t, append(args, "--host", "dev.local"), genCertFlags(t)...)
+	if err := genCertAction(context); err != nil {
+		t.Fatalf("Did not expect an error generating a second certificate, error: %v", err)
+	}
+	if reused, _ := ioutil.ReadFile(caFile); !bytes.Equal(reused, ca) {
+		t.Errorf("Expected the ca to be reused")
+	}
+	verify(t, filepath.Join(dir, "server.pem"), roots, []string{"shop.example.org", "dev.local"})
+
+	// a leaf cannot stand in for the ca
+	args = []string{
+		"--file", mappingPath, "--ca", "--ca-cert", filepath.Join(dir, "server.pem"), "--ca-key", filepath.Join(dir, "server.key"),
+		"--cert", filepath.Join(dir, "other.pem"), "--key", filepath.Join(dir, "other.key"),
+	}
+	err = genCertAction(newCommandContext(t, args, genCertFlags(t)...))
+	if exitErr, ok := err.(*cli.ExitError); !ok || exitErr.ExitCode() != errors.ExitCodeBadCert {
+		t.Errorf("Expected a certificate which is not a ca to be refused, got: %v", err)
+	}
+}
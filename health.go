@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"go-redirector/certstore"
+	"io/ioutil"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// StatusOK is reported when the server is ready to serve redirects
+	StatusOK = "ok"
+	// StatusDraining is reported once shutdown starts, see FastServer.Draining
+	StatusDraining = "draining"
+	// StatusNoMapping is reported when no valid mapping file is loaded
+	StatusNoMapping = "no_mapping"
+)
+
+// MappingStatus describes the mapping file in use
+type MappingStatus struct {
+	Path            string     `json:"path"`
+	Checksum        string     `json:"checksum,omitempty"` // hex encoded sha256 of the file
+	LoadedAt        *time.Time `json:"loaded_at,omitempty"`
+	Hosts           int        `json:"hosts"`
+	Entries         int        `json:"entries"`
+	LastReloadError string     `json:"last_reload_error,omitempty"` // cleared by the next successful reload
+}
+
+// TLSStatus describes the certificates served, only reported when TLS is enabled
+type TLSStatus struct {
+	Cert            string           `json:"cert,omitempty"`
+	ACME            string           `json:"acme,omitempty"`      // directory certificates are obtained from
+	NotAfter        *time.Time       `json:"not_after,omitempty"` // of the server cert, or the first acme certificate to expire
+	Error           string           `json:"error,omitempty"`     // why the certificate could not be read
+	LoadedAt        *time.Time       `json:"loaded_at,omitempty"`
+	Certificates    []certstore.Info `json:"certificates,omitempty"`      // every certificate served, the server cert first
+	LastReloadError string           `json:"last_reload_error,omitempty"` // cleared by the next successful reload
+}
+
+// HealthStatus is the body of `/livez` and `/readyz`
+type HealthStatus struct {
+	Status  string        `json:"status"` // StatusOK, StatusDraining or StatusNoMapping
+	Mapping MappingStatus `json:"mapping"`
+	TLS     *TLSStatus    `json:"tls,omitempty"`
+}
+
+// setReloadError records the outcome of the last reload, nil clears a previous error
+func (f *FastServer) setReloadError(err error) {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	f.reloadErr = err
+}
+
+// certExpiry reads the first certificate of a pem file and returns when it expires
+func certExpiry(certFile string) (time.Time, error) {
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return time.Time{}, fmt.Errorf("no pem encoded certificate found in [%s]", certFile)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cert.NotAfter, nil
+}
+
+// tlsStatus describes the certificates currently served, read from the server cert when none are loaded yet, or cached from the acme directory
+func (f *FastServer) tlsStatus() *TLSStatus {
+	if f.acme != nil {
+		status := &TLSStatus{ACME: f.Config.ACMEDirectory, Certificates: f.acmeCertInfos()}
+		for index := range status.Certificates {
+			if notAfter := &status.Certificates[index].NotAfter; status.NotAfter == nil || notAfter.Before(*status.NotAfter) {
+				status.NotAfter = notAfter
+			}
+		}
+		return status
+	}
+
+	status := &TLSStatus{Cert: f.Config.ServerCert}
+
+	f.statusMu.Lock()
+	if f.certsErr != nil {
+		status.LastReloadError = f.certsErr.Error()
+	}
+	f.statusMu.Unlock()
+
+	if store := f.CertStore(); store != nil {
+		infos := store.Infos()
+		loadedAt := store.LoadedAt
+		status.NotAfter = &infos[0].NotAfter
+		status.LoadedAt = &loadedAt
+		status.Certificates = infos
+		return status
+	}
+
+	if notAfter, err := certExpiry(f.Config.ServerCert); err != nil {
+		status.Error = err.Error()
+	} else {
+		status.NotAfter = &notAfter
+	}
+
+	return status
+}
+
+// healthStatus describes the state of the server, as served by `/livez` and `/readyz`
+func (f *FastServer) healthStatus() *HealthStatus {
+	status := &HealthStatus{
+		Status:  StatusOK,
+		Mapping: MappingStatus{Path: f.Config.MappingPath},
+	}
+
+	f.statusMu.Lock()
+	if !f.loadedAt.IsZero() {
+		loadedAt := f.loadedAt
+		status.Mapping.LoadedAt = &loadedAt
+	}
+	if f.reloadErr != nil {
+		status.Mapping.LastReloadError = f.reloadErr.Error()
+	}
+	f.statusMu.Unlock()
+
+	if mappingFile := f.MappingFile(); mappingFile != nil {
+		status.Mapping.Checksum = mappingFile.Checksum()
+		status.Mapping.Hosts = len(mappingFile.Mappings)
+		status.Mapping.Entries = mappingFile.EntryCount()
+	} else {
+		status.Status = StatusNoMapping
+	}
+
+	if f.Draining() {
+		status.Status = StatusDraining
+	}
+
+	if !f.Config.UseHTTP {
+		status.TLS = f.tlsStatus()
+	}
+
+	return status
+}
+
+/*
+*
+Respond to liveness probes, only if host is localhost like health. The process is alive as long as
+it answers, so this is always 200 with the state of the server in the body.
+*/
+func (f *FastServer) livez(c *fiber.Ctx) error {
+	return c.Status(200).JSON(f.healthStatus())
+}
+
+/*
+*
+Respond to readiness probes, only if host is localhost like health. Fails with 503 when no valid
+mapping file is loaded or while draining, with the state of the server in the body.
+*/
+func (f *FastServer) readyz(c *fiber.Ctx) error {
+	status := f.healthStatus()
+	if status.Status != StatusOK {
+		return c.Status(503).JSON(status)
+	}
+
+	return c.Status(200).JSON(status)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// probe requests a health endpoint as localhost, decoding the status in the body
+func probe(t *testing.T, fastServer *FastServer, target string) (int, *HealthStatus) {
+	request := httptest.NewRequest("GET", target, nil)
+	request.Host = "localhost"
+	resp, err := fastServer.server.Test(request)
+	if err != nil {
+		t.Fatalf("Did not expect to get an error testing target [%s], error: %v", target, err)
+	}
+
+	status := &HealthStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		t.Fatalf("Expected a json body from [%s], error: %v", target, err)
+	}
+
+	return resp.StatusCode, status
+}
+
+func Test_CertExpiry(t *testing.T) {
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	certFile, _ := writeTestPairUntil(t, t.TempDir(), "server", notAfter, "localhost")
+	if expiry, err := certExpiry(certFile); err != nil {
+		t.Errorf("Did not expect an error reading the certificate, error: %v", err)
+	} else if !expiry.Equal(notAfter) {
+		t.Errorf("Expected expiry [%s], got [%s]", notAfter, expiry)
+	}
+
+	if _, err := certExpiry("./tests/test-redirect-map.yml"); err == nil {
+		t.Errorf("Expected an error reading a file which is not a certificate")
+	}
+	if _, err := certExpiry("./tests/noop.pem"); err == nil {
+		t.Errorf("Expected an error reading a missing file")
+	}
+}
+
+func Test_LivezReadyz(t *testing.T) {
+	fastServer, mappingPath := newReloadServer(t)
+	fastServer.Config.UseHTTP = true
+	fastServer.setup()
+
+	for _, target := range []string{"/livez", "/readyz"} {
+		code, status := probe(t, fastServer, target)
+		if code != 200 || status.Status != StatusOK {
+			t.Errorf("Expected [200 %s] from [%s], got [%d %s]", StatusOK, target, code, status.Status)
+		}
+		if status.Mapping.Path != mappingPath || len(status.Mapping.Checksum) != 64 || status.Mapping.LoadedAt == nil {
+			t.Errorf("Expected the mapping file in use from [%s], got [%+v]", target, status.Mapping)
+		}
+		if status.Mapping.Hosts != 1 || status.Mapping.Entries != 3 {
+			t.Errorf("Expected [1] host and [3] entries from [%s], got [%d] and [%d]", target, status.Mapping.Hosts, status.Mapping.Entries)
+		}
+		if status.TLS != nil {
+			t.Errorf("Did not expect tls to be reported in http mode, got [%+v]", status.TLS)
+		}
+
+		request := httptest.NewRequest("GET", target, nil)
+		request.Host = "example.com"
+		if resp, err := fastServer.server.Test(request); err != nil {
+			t.Errorf("Did not expect to get an error testing target [%s], error: %v", target, err)
+		} else if resp.StatusCode != 404 {
+			t.Errorf("Expected [404] from [%s] for another host, got [%d]", target, resp.StatusCode)
+		}
+	}
+
+	// a failed reload is reported, the mappings in use stay ready
+	copyFile(t, "./tests/bad-redirect-map.yml", mappingPath)
+	_ = fastServer.reloadMappingFile()
+	if code, status := probe(t, fastServer, "/readyz"); code != 200 || status.Mapping.LastReloadError == "" {
+		t.Errorf("Expected ready with the last reload error, got [%d] [%+v]", code, status.Mapping)
+	}
+
+	atomic.StoreInt32(&fastServer.draining, 1)
+	if code, status := probe(t, fastServer, "/readyz"); code != 503 || status.Status != StatusDraining {
+		t.Errorf("Expected [503 %s] while draining, got [%d %s]", StatusDraining, code, status.Status)
+	}
+	if code, _ := probe(t, fastServer, "/livez"); code != 200 {
+		t.Errorf("Expected [200] from /livez while draining, got [%d]", code)
+	}
+}
+
+func Test_ReadyzWithoutMapping(t *testing.T) {
+	config := NewConfig()
+	config.UseHTTP = true
+	fastServer := NewFastServer(config, nil)
+	fastServer.setup()
+
+	if code, status := probe(t, fastServer, "/readyz"); code != 503 || status.Status != StatusNoMapping {
+		t.Errorf("Expected [503 %s] without a mapping file, got [%d %s]", StatusNoMapping, code, status.Status)
+	}
+}
+
+func Test_ReadyzTLS(t *testing.T) {
+	notAfter := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	certFile, keyFile := writeTestPairUntil(t, t.TempDir(), "server", notAfter, "localhost")
+	config := NewConfig()
+	config.setMappingFile("./tests/test-redirect-map.yml")
+	config.setHTTP(false, certFile, keyFile)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+
+	_, status := probe(t, fastServer, "/readyz")
+	if status.TLS == nil || status.TLS.NotAfter == nil || !status.TLS.NotAfter.Equal(notAfter) {
+		t.Errorf("Expected the certificate to expire at [%s], got [%+v]", notAfter, status.TLS)
+	}
+}
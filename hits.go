@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"go-redirector/metrics"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// HitsRoute is the admin route reporting the hits of every mapping entry
+const HitsRoute = "/admin/hits"
+
+// EntryHits are the hits of a mapping entry in use
+type EntryHits struct {
+	Host    string     `json:"host"`
+	Path    string     `json:"path"`
+	Count   uint64     `json:"count"`
+	LastHit *time.Time `json:"last_hit,omitempty"` // nil when never hit
+}
+
+// HitsReport lists the hits of every entry of the mapping file in use
+type HitsReport struct {
+	Since   time.Time   `json:"since"` // when counting started, across restarts when hits are persisted
+	Entries []EntryHits `json:"entries"`
+}
+
+func (c *Config) setHits(file string, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultHitsInterval
+	}
+	c.HitsFile = file
+	c.HitsInterval = interval
+}
+
+// observeHit records a request answered by the entry `path` of `host`
+func (f *FastServer) observeHit(host string, path string, at time.Time) {
+	f.Hits.Observe(host, path, at)
+}
+
+// entryHits returns the hits of every entry of the mapping file in use, sorted by host then path
+func (f *FastServer) entryHits() []EntryHits {
+	mappingFile := f.MappingFile()
+	entries := []EntryHits{}
+	if mappingFile == nil {
+		return entries
+	}
+
+	hosts := make([]string, 0, len(mappingFile.Mappings))
+	for host := range mappingFile.Mappings {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	for _, host := range hosts {
+		mapping := mappingFile.Mappings[host]
+		if mapping == nil {
+			continue
+		}
+		paths := make([]string, 0, len(*mapping))
+		for path := range *mapping {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			hit := f.Hits.Get(host, path)
+			entry := EntryHits{Host: host, Path: path, Count: hit.Count}
+			if !hit.LastHit.IsZero() {
+				lastHit := hit.LastHit
+				entry.LastHit = &lastHit
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// setEntryHits hands the hits of every entry to the prometheus exporter, before it is scraped
+func (f *FastServer) setEntryHits() {
+	entries := f.entryHits()
+	entryHits := make([]metrics.EntryHits, 0, len(entries))
+	for _, entry := range entries {
+		hit := metrics.EntryHits{Host: entry.Host, Path: entry.Path, Count: entry.Count}
+		if entry.LastHit != nil {
+			hit.LastHit = *entry.LastHit
+		}
+		entryHits = append(entryHits, hit)
+	}
+	f.PrometheusExporter.SetEntryHits(entryHits)
+}
+
+/*
+*
+Respond with the hits of every mapping entry as json, only to localhost over loopback, see localOnly.
+The `idle` query parameter, a duration such as `720h`, only lists entries not hit for that long.
+*/
+func (f *FastServer) hitsReport(c *fiber.Ctx) error {
+	entries := f.entryHits()
+	if idle := c.Query("idle"); idle != "" {
+		period, err := time.ParseDuration(idle)
+		if err != nil || period < 0 {
+			return c.Status(400).SendString("idle must be a duration, e.g. 720h")
+		}
+
+		cutoff := time.Now().Add(-period)
+		idleEntries := []EntryHits{}
+		for _, entry := range entries {
+			if entry.LastHit == nil || entry.LastHit.Before(cutoff) {
+				idleEntries = append(idleEntries, entry)
+			}
+		}
+		entries = idleEntries
+	}
+
+	return c.Status(200).JSON(HitsReport{Since: f.Hits.Since(), Entries: entries})
+}
+
+// loadHits adds the hits persisted by an earlier run, when they are persisted
+func (f *FastServer) loadHits() {
+	if f.Config.HitsFile == "" {
+		return
+	}
+
+	if err := f.Hits.Load(f.Config.HitsFile); err != nil {
+		log.Error().Msg(fmt.Sprintf("Could not load hits from [%s], counting from zero: %v", f.Config.HitsFile, err))
+		return
+	}
+	log.Info().Msg(fmt.Sprintf("Loaded hits from [%s]", f.Config.HitsFile))
+}
+
+// saveHits persists the hits, when they are persisted. Saves are serialized, so the latest always wins.
+func (f *FastServer) saveHits() {
+	if f.Config.HitsFile == "" {
+		return
+	}
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	if err := f.Hits.Save(f.Config.HitsFile); err != nil {
+		log.Error().Msg(fmt.Sprintf("Could not save hits to [%s]: %v", f.Config.HitsFile, err))
+	}
+}
+
+// persistHits saves the hits every `interval` until `stop` is closed
+func (f *FastServer) persistHits(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			f.saveHits()
+		}
+	}
+}
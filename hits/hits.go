@@ -0,0 +1,191 @@
+package hits
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Hit counts the requests answered by a single mapping entry
+type Hit struct {
+	Host    string    `json:"host"`
+	Path    string    `json:"path"`
+	Count   uint64    `json:"count"`
+	LastHit time.Time `json:"last_hit"`
+}
+
+// snapshot is the content of a persisted hits file
+type snapshot struct {
+	Since   time.Time `json:"since"`
+	SavedAt time.Time `json:"saved_at"`
+	Hits    []Hit     `json:"hits"`
+}
+
+type key struct {
+	host string
+	path string
+}
+
+// Counter counts hits per mapping host and path key. A nil Counter counts nothing.
+type Counter struct {
+	mu    sync.Mutex
+	hits  map[key]*Hit
+	since time.Time
+}
+
+// NewCounter is a factory which creates a new, empty Counter.
+func NewCounter() *Counter {
+	return &Counter{hits: map[key]*Hit{}, since: time.Now()}
+}
+
+// Observe records a hit of the entry `path` of `host`, at `at`
+func (c *Counter) Observe(host string, path string, at time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hit, ok := c.hits[key{host, path}]
+	if !ok {
+		hit = &Hit{Host: host, Path: path}
+		c.hits[key{host, path}] = hit
+	}
+	hit.Count++
+	if at.After(hit.LastHit) {
+		hit.LastHit = at
+	}
+}
+
+// Since returns when counting started, the oldest start when hits were restored
+func (c *Counter) Since() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.since
+}
+
+// Get returns the hits of a single entry, with a count of 0 when it was never hit
+func (c *Counter) Get(host string, path string) Hit {
+	if c == nil {
+		return Hit{Host: host, Path: path}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit, ok := c.hits[key{host, path}]; ok {
+		return *hit
+	}
+
+	return Hit{Host: host, Path: path}
+}
+
+// Snapshot returns every entry hit, sorted by host then path
+func (c *Counter) Snapshot() []Hit {
+	if c == nil {
+		return []Hit{}
+	}
+
+	c.mu.Lock()
+	hits := make([]Hit, 0, len(c.hits))
+	for _, hit := range c.hits {
+		hits = append(hits, *hit)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Host != hits[j].Host {
+			return hits[i].Host < hits[j].Host
+		}
+		return hits[i].Path < hits[j].Path
+	})
+
+	return hits
+}
+
+// restore adds hits counted elsewhere, keeping the latest hit time of each entry
+func (c *Counter) restore(since time.Time, hits []Hit) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !since.IsZero() && since.Before(c.since) {
+		c.since = since
+	}
+	for _, restored := range hits {
+		hit, ok := c.hits[key{restored.Host, restored.Path}]
+		if !ok {
+			hit = &Hit{Host: restored.Host, Path: restored.Path}
+			c.hits[key{restored.Host, restored.Path}] = hit
+		}
+		hit.Count += restored.Count
+		if restored.LastHit.After(hit.LastHit) {
+			hit.LastHit = restored.LastHit
+		}
+	}
+}
+
+/*
+*
+Load adds the hits persisted in `file` by Save to the counter. A missing file is not an error,
+nothing has been persisted yet.
+*/
+func (c *Counter) Load(file string) error {
+	if c == nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved snapshot
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	c.restore(saved.Since, saved.Hits)
+
+	return nil
+}
+
+/*
+*
+Save persists every hit to `file` as json. The file is written next to its destination and then
+renamed over it, so a crash never leaves a partial file behind.
+*/
+func (c *Counter) Save(file string) error {
+	if c == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(snapshot{Since: c.Since(), SavedAt: time.Now(), Hits: c.Snapshot()}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
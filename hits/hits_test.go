@@ -0,0 +1,79 @@
+package hits
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Counter(t *testing.T) {
+	counter := NewCounter()
+	first := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	counter.Observe("old.example.org", "/docs/*", first)
+	counter.Observe("old.example.org", "/docs/*", first.Add(time.Hour))
+	counter.Observe("old.example.org", "/docs/*", first) // out of order, the last hit stays the latest
+	counter.Observe("blog.example.org", "/", first)
+
+	if hit := counter.Get("old.example.org", "/docs/*"); hit.Count != 3 || !hit.LastHit.Equal(first.Add(time.Hour)) {
+		t.Errorf("Expected [3] hits, last at [%s], got %+v", first.Add(time.Hour), hit)
+	}
+	if hit := counter.Get("old.example.org", "/never"); hit.Count != 0 || !hit.LastHit.IsZero() || hit.Path != "/never" {
+		t.Errorf("Expected no hits for an entry never hit, got %+v", hit)
+	}
+
+	snapshot := counter.Snapshot()
+	if len(snapshot) != 2 || snapshot[0].Host != "blog.example.org" || snapshot[1].Host != "old.example.org" {
+		t.Errorf("Expected [2] entries sorted by host, got %+v", snapshot)
+	}
+
+	// a nil counter counts nothing, without panicking
+	var none *Counter
+	none.Observe("old.example.org", "/", first)
+	if len(none.Snapshot()) != 0 || none.Get("h", "/").Count != 0 || none.Save("unused") != nil || none.Load("unused") != nil {
+		t.Errorf("Expected a nil counter to do nothing")
+	}
+}
+
+func Test_SaveLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hits.json")
+
+	// nothing persisted yet
+	counter := NewCounter()
+	if err := counter.Load(file); err != nil {
+		t.Errorf("Did not expect an error loading a missing file, error: %v", err)
+	}
+
+	last := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	counter.Observe("old.example.org", "/docs/*", last)
+	counter.Observe("old.example.org", "/docs/*", last)
+	if err := counter.Save(file); err != nil {
+		t.Fatalf("Did not expect an error saving, error: %v", err)
+	}
+	if matches, _ := filepath.Glob(file + ".*.tmp"); len(matches) != 0 {
+		t.Errorf("Expected no temporary files left behind, got %v", matches)
+	}
+
+	// counts carry on across restarts
+	restarted := NewCounter()
+	restarted.Observe("old.example.org", "/docs/*", last.Add(-time.Hour))
+	if err := restarted.Load(file); err != nil {
+		t.Fatalf("Did not expect an error loading, error: %v", err)
+	}
+	if hit := restarted.Get("old.example.org", "/docs/*"); hit.Count != 3 || !hit.LastHit.Equal(last) {
+		t.Errorf("Expected [3] hits, last at [%s], got %+v", last, hit)
+	}
+	if !restarted.Since().Equal(counter.Since()) {
+		t.Errorf("Expected counting to date from [%s], got [%s]", counter.Since(), restarted.Since())
+	}
+
+	if err := ioutil.WriteFile(file, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewCounter().Load(file); err == nil {
+		t.Errorf("Expected an error loading a corrupt file")
+	}
+	if err := counter.Save(filepath.Join(t.TempDir(), "missing", "hits.json")); err == nil {
+		t.Errorf("Expected an error saving in a missing directory")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_SetHits(t *testing.T) {
+	config := NewConfig()
+	config.setHits("", 0)
+	if config.HitsFile != "" || config.HitsInterval != DefaultHitsInterval {
+		t.Errorf("Expected no hits file every [%s], got [%s] every [%s]", DefaultHitsInterval, config.HitsFile, config.HitsInterval)
+	}
+	config.setHits("./hits.json", time.Hour)
+	if config.HitsFile != "./hits.json" || config.HitsInterval != time.Hour {
+		t.Errorf("Expected [./hits.json] every [1h], got [%s] every [%s]", config.HitsFile, config.HitsInterval)
+	}
+}
+
+func Test_HitsReport(t *testing.T) {
+	config := NewConfig()
+	config.setMappingFile("./tests/test-redirect-map.yml")
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+
+	get := serveLoopback(t, fastServer)
+
+	get("testhost", "/direct")
+	get("testhost", "/direct")
+	get("testhost", "/my-path")
+	get("testhost", "/missing") // not an entry, not a hit
+
+	if resp := get("testhost", HitsRoute); resp.StatusCode != 404 {
+		t.Errorf("Expected [404] for the report on another host, got [%d]", resp.StatusCode)
+	}
+	request := httptest.NewRequest("GET", HitsRoute, nil)
+	request.Host = "localhost"
+	if resp, err := fastServer.server.Test(request); err != nil || resp.StatusCode != 404 {
+		t.Errorf("Expected [404] for the report from a remote client, got [%v] with error: %v", resp, err)
+	}
+	if resp := get("localhost", HitsRoute+"?idle=soon"); resp.StatusCode != 400 {
+		t.Errorf("Expected [400] for a bad idle period, got [%d]", resp.StatusCode)
+	}
+
+	report := func(target string) HitsReport {
+		resp := get("localhost", target)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Expected [200] for [%s], got [%d]", target, resp.StatusCode)
+		}
+		var report HitsReport
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatalf("Expected a json report, error: %v", err)
+		}
+		return report
+	}
+
+	counts := map[string]uint64{}
+	for _, entry := range report(HitsRoute).Entries {
+		counts[entry.Host+entry.Path] = entry.Count
+		if (entry.Count == 0) != (entry.LastHit == nil) {
+			t.Errorf("Expected a last hit only for entries hit, got %+v", entry)
+		}
+	}
+	if counts["testhost/direct"] != 2 || counts["testhost/my-path"] != 1 || counts["testhost/file-1"] != 0 {
+		t.Errorf("Expected every entry with its hits, got %v", counts)
+	}
+	if _, ok := counts["testhost/missing"]; ok {
+		t.Errorf("Did not expect misses among the entries")
+	}
+
+	// entries never hit, or not hit within the idle period
+	for _, entry := range report(HitsRoute + "?idle=1h").Entries {
+		if entry.Count != 0 {
+			t.Errorf("Expected only entries never hit, got %+v", entry)
+		}
+	}
+	if idle := report(HitsRoute + "?idle=0s").Entries; len(idle) != len(counts) {
+		t.Errorf("Expected every entry idle for [0s], got [%d] of [%d]", len(idle), len(counts))
+	}
+
+	request = httptest.NewRequest("GET", "/metrics", nil)
+	request.Host = "localhost"
+	resp, _ := fastServer.server.Test(request)
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, expected := range []string{
+		`redirector_entry_hits{host="testhost",path="/direct"} 2`,
+		`redirector_entry_hits{host="testhost",path="/file-1"} 0`,
+		`redirector_entry_last_hit_timestamp_seconds{host="testhost",path="/file-1"} 0`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected to find [%s] in the metrics", expected)
+		}
+	}
+}
+
+func Test_PersistHits(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hits.json")
+	config := NewConfig()
+	config.setMappingFile("./tests/test-redirect-map.yml")
+	config.setHits(file, 10*time.Millisecond)
+
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.loadHits() // nothing persisted yet
+	fastServer.observeHit("testhost", "/direct", time.Now())
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		fastServer.persistHits(config.HitsInterval, stop)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	<-done
+
+	// counts survive a restart
+	restarted := NewFastServer(config, config.MappingsFile)
+	restarted.loadHits()
+	restarted.observeHit("testhost", "/direct", time.Now())
+	if hit := restarted.Hits.Get("testhost", "/direct"); hit.Count != 2 {
+		t.Errorf("Expected [2] hits across restarts, got [%d]", hit.Count)
+	}
+	restarted.saveHits()
+
+	again := NewFastServer(config, config.MappingsFile)
+	again.loadHits()
+	if hit := again.Hits.Get("testhost", "/direct"); hit.Count != 2 {
+		t.Errorf("Expected [2] hits once saved again, got [%d]", hit.Count)
+	}
+}
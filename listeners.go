@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"go-redirector/errors"
+	"go-redirector/mapping"
+	"go-redirector/metrics"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultUpgradePort is the default port plain http requests are upgraded to
+	DefaultUpgradePort = 443
+
+	// UpgradeStatus is the status plain http requests are upgraded to https with
+	UpgradeStatus = 301
+)
+
+/*
+*
+Set the plain http listener run next to the tls listener, 0 disables it. Requests it receives are
+upgraded to https on `upgradePort`, or served, depending on the http mode of their host. It is
+ignored when the server only serves plain http.
+*/
+func (c *Config) setHTTPListener(httpPort int, upgradePort int) {
+	if httpPort == 0 {
+		c.HTTPPort = 0
+		return
+	}
+	if c.UseHTTP {
+		log.Warn().Msg(fmt.Sprintf("Ignoring http port [%d], the server only serves plain http", httpPort))
+		c.HTTPPort = 0
+		return
+	}
+	if upgradePort == 0 {
+		upgradePort = DefaultUpgradePort
+	}
+
+	for _, port := range []int{httpPort, upgradePort} {
+		if port < 0 || port > 65535 {
+			log.Error().Msg(fmt.Sprintf("Port [%d] is not a valid port", port))
+			c.exitFunc(errors.ExitCodeBadPort)
+			return
+		}
+	}
+	if httpPort == c.Port {
+		log.Error().Msg(fmt.Sprintf("Http port [%d] is already used by the tls listener", httpPort))
+		c.exitFunc(errors.ExitCodeBadPort)
+		return
+	}
+
+	c.HTTPPort = httpPort
+	c.UpgradePort = upgradePort
+	log.Info().Msg(fmt.Sprintf("Serving plain http on port [%d] next to tls.", httpPort))
+}
+
+// upgradeTarget is the https uri a plain http request is upgraded to
+func upgradeTarget(host string, port int, requestURI string) string {
+	if port == DefaultUpgradePort {
+		return fmt.Sprintf("https://%s%s", host, requestURI)
+	}
+
+	return fmt.Sprintf("https://%s:%d%s", host, port, requestURI)
+}
+
+/*
+*
+Upgrade a plain http request to https, when the server also serves https and the requested host is
+mapped with the http mode HTTPUpgrade. Reports whether the request was upgraded.
+*/
+func (f *FastServer) upgrade(c *fiber.Ctx, origin requestOrigin, start time.Time) (bool, error) {
+	if f.Config.HTTPPort == 0 || origin.Scheme != "http" {
+		return false, nil
+	}
+
+	mappingFile := f.MappingFile()
+	if mappingFile == nil {
+		return false, nil
+	}
+	// unmapped hosts get the 404 they get over https, counted as a miss
+	host := f.parseHost(origin.Host)
+	mappedHost, ok := mappingFile.ResolveHost(host)
+	if !ok || mappingFile.HTTPMode(host) != mapping.HTTPUpgrade {
+		return false, nil
+	}
+
+	resolution := &Resolution{
+		Host:   mappedHost,
+		Mode:   ModeImmediate,
+		Status: UpgradeStatus,
+		Target: upgradeTarget(host, f.Config.UpgradePort, string(c.Request().RequestURI())),
+	}
+
+	err := c.Redirect(resolution.Target, resolution.Status)
+	f.observeRequest(mappedHost, "", metrics.OutcomeUpgrade, start)
+	f.logAccess(c, origin, resolution, metrics.OutcomeUpgrade, start)
+	return true, err
+}
+
+/*
+*
+Listen on every configured listener until one of them fails, or until shutdown closes them all.
+Plain http only, tls only, or tls with a plain http listener next to it.
+*/
+func (f *FastServer) listen(server *fiber.App) error {
+	if f.Config.UseHTTP {
+		return server.Listen(fmt.Sprintf(":%d", f.Config.Port))
+	}
+
+	listenTLS := func() error {
+		return f.listenTLS(server, fmt.Sprintf(":%d", f.Config.Port))
+	}
+	if f.Config.HTTPPort == 0 {
+		return listenTLS()
+	}
+
+	return firstError(listenTLS, func() error {
+		return server.Listen(fmt.Sprintf(":%d", f.Config.HTTPPort))
+	})
+}
+
+// firstError runs every listener at once, returning as soon as one of them returns
+func firstError(listeners ...func() error) error {
+	served := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener func() error) {
+			served <- listener()
+		}(listener)
+	}
+
+	return <-served
+}
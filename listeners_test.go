@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"go-redirector/errors"
+	"go-redirector/mapping"
+	"net/http/httptest"
+	"testing"
+)
+
+const httpModeMappingFile = `---
+defaults:
+  http: upgrade
+hosts:
+  plain.example.org:
+    http: serve
+mapping:
+  secure.example.org:
+    "/":
+      immediate: true
+      redirect: https://new.example.org
+  plain.example.org:
+    "/":
+      immediate: true
+      redirect: https://new.example.org
+`
+
+func Test_SetHTTPListener(t *testing.T) {
+	config := NewConfig()
+	config.exitFunc = func(code int) {
+		t.Errorf("Did not expect to see the app exit on valid ports, code: %d", code)
+	}
+	config.setHTTP(false, DefaultServerCert, DefaultServerKey)
+	config.setPort(0)
+
+	if config.setHTTPListener(0, 0); config.HTTPPort != 0 {
+		t.Errorf("Expected no http listener by default, got [%d]", config.HTTPPort)
+	}
+	if config.setHTTPListener(8080, 0); config.HTTPPort != 8080 || config.UpgradePort != DefaultUpgradePort {
+		t.Errorf("Expected http on [8080] upgrading to [%d], got [%d] and [%d]", DefaultUpgradePort, config.HTTPPort, config.UpgradePort)
+	}
+
+	plain := NewConfig()
+	plain.setHTTP(true, "", "")
+	if plain.setHTTPListener(8081, 0); plain.HTTPPort != 0 {
+		t.Errorf("Expected the http port to be ignored when only serving plain http, got [%d]", plain.HTTPPort)
+	}
+
+	for _, ports := range [][2]int{{DefaultPortTLS, 443}, {70000, 443}, {8080, -1}} {
+		exitReached := false
+		config.exitFunc = func(code int) {
+			if code != errors.ExitCodeBadPort {
+				t.Errorf("Expected exit code of [%v], got [%v]", errors.ExitCodeBadPort, code)
+			}
+			exitReached = true
+		}
+		config.setHTTPListener(ports[0], ports[1])
+		if !exitReached {
+			t.Errorf("Expected the app to exit for http port [%d] upgrading to [%d]", ports[0], ports[1])
+		}
+	}
+}
+
+func Test_UpgradeTarget(t *testing.T) {
+	if target := upgradeTarget("old.example.org", 443, "/docs?page=2"); target != "https://old.example.org/docs?page=2" {
+		t.Errorf("Expected no port for 443, got [%s]", target)
+	}
+	if target := upgradeTarget("old.example.org", 8443, "/"); target != "https://old.example.org:8443/" {
+		t.Errorf("Expected the port to be kept, got [%s]", target)
+	}
+}
+
+func Test_Upgrade(t *testing.T) {
+	mappingFile, err := mapping.Parse([]byte(httpModeMappingFile))
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	testData := []struct {
+		httpPort         int
+		proxied          bool
+		host             string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{8080, false, "secure.example.org", 301, "https://secure.example.org/docs?page=2"},
+		{8080, false, "secure.example.org:8080", 301, "https://secure.example.org/docs?page=2"},
+		{8080, false, "unknown.example.org", 404, ""},                           // not mapped, a miss like over https
+		{8080, false, "plain.example.org", 302, "https://new.example.org/docs"}, // served as is
+		{0, false, "secure.example.org", 302, "https://new.example.org/docs"},   // without an http listener
+		{8080, true, "secure.example.org", 302, "https://new.example.org/docs"}, // https already, at the proxy
+		{8080, false, "localhost", 200, ""},                                     // probes are never upgraded
+	}
+
+	for _, test := range testData {
+		config := NewConfig()
+		config.HTTPPort = test.httpPort
+		config.setProxies([]string{"0.0.0.0"}, HeaderXForwardedFor)
+		fastServer := NewFastServer(config, mappingFile)
+		fastServer.setup()
+
+		target := "/docs?page=2"
+		if test.host == "localhost" {
+			target = "/healthy?page=2"
+		}
+		request := httptest.NewRequest("GET", target, nil)
+		request.Host = test.host
+		if test.proxied {
+			request.Header.Set(HeaderXForwardedProto, "https")
+		}
+
+		resp, err := fastServer.server.Test(request)
+		if err != nil {
+			t.Fatalf("Did not expect to get an error testing [%s], error: %v", test.host, err)
+		}
+		if resp.StatusCode != test.expectedStatus || resp.Header.Get("Location") != test.expectedLocation {
+			t.Errorf("Expected [%d] to [%s] for [%s] with http port [%d], got [%d] to [%s]",
+				test.expectedStatus, test.expectedLocation, test.host, test.httpPort, resp.StatusCode, resp.Header.Get("Location"))
+		}
+		if missed := fastServer.Misses.Top(0).Total; (test.expectedStatus == 404) != (missed == 1) {
+			t.Errorf("Expected only a 404 to count as a miss for [%s], got [%d] miss(es)", test.host, missed)
+		}
+	}
+}
+
+func Test_FirstError(t *testing.T) {
+	failed := fmt.Errorf("address in use")
+	release := make(chan struct{})
+	defer close(release)
+
+	err := firstError(
+		func() error {
+			<-release
+			return nil
+		},
+		func() error {
+			return failed
+		},
+	)
+	if err != failed {
+		t.Errorf("Expected the first listener to fail to be returned, got: %v", err)
+	}
+}
@@ -1,19 +1,31 @@
 package main
 
 import (
+	"crypto/x509"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
+	"go-redirector/accesslog"
+	"go-redirector/certstore"
 	"go-redirector/errors"
+	"go-redirector/hits"
 	"go-redirector/mapping"
+	"go-redirector/metrics"
+	"go-redirector/misses"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/fiber/v2/middleware/favicon"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli"
+	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/gofiber/template/html"
 )
@@ -44,6 +56,51 @@ const (
 	ServerCert = "SERVER_CERT"
 	// ServerKey is the env var name to use
 	ServerKey = "SERVER_KEY"
+	// WatchInterval is the env var name to use
+	WatchInterval = "WATCH_INTERVAL"
+	// TestMappings is the env var name to use
+	TestMappings = "TEST_MAPPINGS"
+	// DrainPeriod is the env var name to use
+	DrainPeriod = "DRAIN_PERIOD"
+	// ShutdownTimeout is the env var name to use
+	ShutdownTimeout = "SHUTDOWN_TIMEOUT"
+	// TrustedProxies is the env var name to use
+	TrustedProxies = "TRUSTED_PROXIES"
+	// ProxyHeader is the env var name to use
+	ProxyHeader = "PROXY_HEADER"
+	// AccessLogFormat is the env var name to use
+	AccessLogFormat = "ACCESS_LOG_FORMAT"
+	// AccessLogFile is the env var name to use
+	AccessLogFile = "ACCESS_LOG_FILE"
+	// RequestIDHeader is the env var name to use
+	RequestIDHeader = "REQUEST_ID_HEADER"
+	// MissCapacity is the env var name to use
+	MissCapacity = "MISS_CAPACITY"
+	// HitsFile is the env var name to use
+	HitsFile = "HITS_FILE"
+	// HitsInterval is the env var name to use
+	HitsInterval = "HITS_INTERVAL"
+	// HTTPPort is the env var name to use
+	HTTPPort = "HTTP_PORT"
+	// UpgradePort is the env var name to use
+	UpgradePort = "UPGRADE_PORT"
+	// CertDir is the env var name to use
+	CertDir = "CERT_DIR"
+	// ACME is the env var name to use
+	ACME = "ACME"
+	// ACMEDirectory is the env var name to use
+	ACMEDirectory = "ACME_DIRECTORY"
+	// ACMECache is the env var name to use
+	ACMECache = "ACME_CACHE"
+	// ACMEEmail is the env var name to use
+	ACMEEmail = "ACME_EMAIL"
+	// ACMECA is the env var name to use
+	ACMECA = "ACME_CA"
+
+	// ModeFriendly is the mode of a redirect answered with the friendly html page
+	ModeFriendly = "friendly"
+	// ModeImmediate is the mode of a redirect answered with an immediate redirect status
+	ModeImmediate = "immediate"
 
 	// DefaultLogLevel is the default log level to use
 	DefaultLogLevel = zerolog.DebugLevel
@@ -57,6 +114,18 @@ const (
 	DefaultServerCert = "./certs/server.pem"
 	// DefaultServerKey is the default file name for the server cert key
 	DefaultServerKey = "./certs/server.key"
+	// DefaultWatchInterval is the default interval between checks of the mapping file for changes
+	DefaultWatchInterval = 5 * time.Second
+	// DefaultDrainPeriod is the default time readiness fails before the server stops accepting connections
+	DefaultDrainPeriod = 5 * time.Second
+	// DefaultShutdownTimeout is the default time in-flight requests are given to finish on shutdown
+	DefaultShutdownTimeout = 10 * time.Second
+	// DefaultIdleTimeout is the longest a keep-alive connection may idle, shutdown waits for idle connections to close
+	DefaultIdleTimeout = 5 * time.Second
+	// DefaultMissCapacity is the default number of missed hosts and paths tracked
+	DefaultMissCapacity = 100
+	// DefaultHitsInterval is the default interval between saves of the hits file
+	DefaultHitsInterval = time.Minute
 )
 
 // ExitFunc is a function type which can be used for exiting the application
@@ -72,6 +141,27 @@ type Config struct {
 	UseHTTP         bool
 	ServerCert      string
 	ServerKey       string
+	WatchInterval   time.Duration
+	TestMappings    bool // run the tests of the mapping file whenever it is loaded
+	DrainPeriod     time.Duration
+	ShutdownTimeout time.Duration
+	TrustedProxies  []*net.IPNet // proxy headers are only honoured on connections from these networks
+	ProxyHeader     string       // HeaderXForwardedFor, HeaderXRealIP or HeaderForwarded
+	AccessLog       *accesslog.Logger
+	AccessLogFile   string // where the access log is written, empty when it shares the application log
+	RequestIDHeader string // header request ids are read from and echoed in
+	MissCapacity    int    // most missed hosts and paths tracked, 0 disables tracking
+	HitsFile        string // where hits are persisted, empty when they are not
+	HitsInterval    time.Duration
+	HTTPPort        int              // port of the plain http listener run next to tls, 0 when there is none
+	UpgradePort     int              // port plain http requests are upgraded to
+	CertDir         string           // certificate and key pairs served by SNI, next to the server cert
+	Certs           *certstore.Store // certificates loaded at startup, nil when only serving plain http or using acme
+	UseACME         bool             // obtain certificates from an acme directory rather than loading them from files
+	ACMEDirectory   string
+	ACMECache       string         // where obtained certificates and the account key are cached
+	ACMEEmail       string         // contact of the acme account, optional
+	ACMERoots       *x509.CertPool // CAs trusted for the acme directory, nil for the system roots
 	exitFunc        ExitFunc
 }
 
@@ -110,13 +200,73 @@ func (c *Config) setMappingFile(filePath string) {
 
 	// use the mapping file
 	if mappingFile, err := mapping.LoadMappingFile(c.MappingPath); err != nil {
-		log.Error().Msg(fmt.Sprintf("Bad mapping file: %v", err))
+		if validationErr, ok := err.(*mapping.ValidationError); ok {
+			log.Error().Msg(fmt.Sprintf("Bad mapping file: %d problem(s) found", len(validationErr.Problems)))
+			logProblems(validationErr)
+		} else {
+			log.Error().Msg(fmt.Sprintf("Bad mapping file: %v", err))
+		}
 		c.exitFunc(errors.ExitCodeBadMappingFile)
 	} else {
 		c.MappingsFile = mappingFile
 	}
 }
 
+func (c *Config) setTestMappings(testMappings bool) {
+	c.TestMappings = testMappings
+	if !testMappings || c.MappingsFile == nil {
+		return
+	}
+
+	if failures := runExpectations(c.MappingsFile); len(failures) > 0 {
+		log.Error().Msg(fmt.Sprintf("Mapping file tests failed: %d of %d test(s)", len(failures), len(c.MappingsFile.Tests)))
+		logFailures(failures)
+		c.exitFunc(errors.ExitCodeMappingTestsFailed)
+	} else {
+		log.Info().Msg(fmt.Sprintf("Mapping file tests passed: %d test(s)", len(c.MappingsFile.Tests)))
+	}
+}
+
+func (c *Config) setWatchInterval(interval time.Duration) {
+	if interval < 0 {
+		interval = 0
+	}
+	c.WatchInterval = interval
+}
+
+func (c *Config) setShutdown(drainPeriod time.Duration, timeout time.Duration) {
+	if drainPeriod < 0 {
+		drainPeriod = 0
+	}
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	c.DrainPeriod = drainPeriod
+	c.ShutdownTimeout = timeout
+}
+
+func (c *Config) setProxies(trustedProxies []string, header string) {
+	trusted, err := parseTrustedProxies(trustedProxies)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("Error: %v", err))
+		c.exitFunc(errors.ExitCodeBadProxyConfig)
+		return
+	}
+
+	proxyHeader, err := parseProxyHeader(header)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("Error: %v", err))
+		c.exitFunc(errors.ExitCodeBadProxyConfig)
+		return
+	}
+
+	c.TrustedProxies = trusted
+	c.ProxyHeader = proxyHeader
+	if len(trusted) > 0 {
+		log.Info().Msg(fmt.Sprintf("Trusting [%s] from [%d] proxy network(s)", proxyHeader, len(trusted)))
+	}
+}
+
 func (c *Config) setLogLevel(logLevel string) {
 	if level, err := zerolog.ParseLevel(strings.ToLower(logLevel)); err != nil {
 		log.Error().Msg(fmt.Sprintf("Error: %v", err))
@@ -152,11 +302,21 @@ func goExit(code int) {
 // NewConfig generates a new Config
 func NewConfig() *Config {
 	mappingPath := setMappingPath()
+	accessLog, _ := accesslog.New(accesslog.FormatJSON, os.Stderr, true)
 
 	return &Config{
-		MappingPath: mappingPath,
-		Port:        DefaultPort,
-		exitFunc:    goExit,
+		MappingPath:     mappingPath,
+		Port:            DefaultPort,
+		WatchInterval:   DefaultWatchInterval,
+		DrainPeriod:     DefaultDrainPeriod,
+		ShutdownTimeout: DefaultShutdownTimeout,
+		ProxyHeader:     DefaultProxyHeader,
+		RequestIDHeader: DefaultRequestIDHeader,
+		MissCapacity:    DefaultMissCapacity,
+		HitsInterval:    DefaultHitsInterval,
+		UpgradePort:     DefaultUpgradePort,
+		AccessLog:       accessLog,
+		exitFunc:        goExit,
 	}
 }
 
@@ -192,6 +352,7 @@ func LoadEnv() *Config {
 // TemplateData is a simple struct to handle redirects
 type TemplateData struct {
 	RedirectURI string
+	RequestID   string // shown on the page, so users can quote it to support
 }
 
 // NewTemplateData returns a struct with all the values needed for templates
@@ -201,45 +362,86 @@ func NewTemplateData(redirectURI string) *TemplateData {
 
 // FastServer represents the server app
 type FastServer struct {
-	Config      *Config
-	MappingFile *mapping.MappingsFile
-	server      *fiber.App
-	//PrometheusExporter *prometheus.Exporter
+	Config             *Config
+	PrometheusExporter *metrics.Exporter
+	Misses             *misses.Tracker // requests which matched no mapping entry, nil when not tracked
+	Hits               *hits.Counter   // requests answered by each mapping entry
+	mappingFile        atomic.Value    // holds the *mapping.MappingsFile currently in use
+	server             *fiber.App
+	stop               chan struct{} // closed to stop watching the mapping file, see stopWatching
+	stopOnce           sync.Once
+	draining           int32             // set to 1 once shutdown starts, see Draining
+	statusMu           sync.Mutex        // guards loadedAt, reloadErr and certsErr
+	loadedAt           time.Time         // when the mapping file in use was loaded
+	reloadErr          error             // error of the last reload, nil when it succeeded
+	certsErr           error             // error of the last certificate reload, nil when it succeeded
+	saveMu             sync.Mutex        // serializes saves of the hits file
+	reloadMu           sync.Mutex        // serializes reloads of the mapping file and the certificates
+	certStore          atomic.Value      // holds the *certstore.Store currently served
+	acme               *autocert.Manager // obtains the certificates served when using acme, nil otherwise
+}
+
+// MappingFile returns the mappings file currently used to serve requests.
+func (f *FastServer) MappingFile() *mapping.MappingsFile {
+	mappingFile, _ := f.mappingFile.Load().(*mapping.MappingsFile)
+	return mappingFile
+}
+
+// swapMappingFile atomically replaces the mappings file used to serve requests.
+func (f *FastServer) swapMappingFile(mappingFile *mapping.MappingsFile) {
+	f.mappingFile.Store(mappingFile)
+	if mappingFile != nil {
+		f.PrometheusExporter.SetMappings(len(mappingFile.Mappings), mappingFile.EntryCount())
+
+		f.statusMu.Lock()
+		f.loadedAt = time.Now()
+		f.statusMu.Unlock()
+	}
+}
+
+// observeRequest records a served request with the prometheus exporter.
+func (f *FastServer) observeRequest(host string, path string, outcome string, start time.Time) {
+	f.PrometheusExporter.ObserveRequest(host, path, outcome, time.Since(start))
 }
 
 /*
 *
-Respond to health only if host is localhost. Simple guard.
+Respond to health only if host is localhost. Simple guard, see localOnly.
 Rely on metrics in future for stats.
 Systems deploying (docker, k8) can craft headers with localhost in probes.
+The Host header is checked as sent, forwarded hosts are never trusted here.
+Fails with 503 once shutdown starts, so no new requests are routed here while draining.
 */
 func (f *FastServer) healthy(c *fiber.Ctx) error {
-	if f.parseHost(c.Hostname()) == "localhost" {
-		return c.SendStatus(200)
+	if f.Draining() {
+		return c.SendStatus(503)
 	}
 
-	return c.SendStatus(404)
+	return c.SendStatus(200)
 }
 
+/*
+*
+Respond with prometheus metrics only if host is localhost, same guard as health, see localOnly.
+Not limited to loopback so remote scrapers work, the entry hits exported here are readable by
+anyone sending `Host: localhost`, unlike the admin hits report.
+*/
 func (f *FastServer) metrics(c *fiber.Ctx) error {
-	if f.parseHost(c.Hostname()) == "localhost" {
-		return c.SendStatus(200)
-	}
-
-	return c.SendStatus(404)
+	f.setEntryHits()
+	c.Set("Content-Type", metrics.ContentType)
+	_, err := f.PrometheusExporter.WriteTo(c)
+	return err
 }
 
 func (f *FastServer) notfound(c *fiber.Ctx) error {
-	host := f.parseHost(c.Hostname())
-	uri := string(c.Request().URI().Path())
-	remoteAddr := c.IP()
-	userAgent := c.Get("User-Agent")
-
-	log.Info().Msg(fmt.Sprintf("Returning 404 for requested page [%s%s], by remote client [%s] with user-agent: [%s]",
-		host, uri, remoteAddr, userAgent,
-	))
-
-	return c.SendStatus(404)
+	start := time.Now()
+	_, logger := f.requestID(c)
+	logger.Debug().Msg(fmt.Sprintf("Not serving [%s]", c.Path()))
+	origin := f.origin(c)
+	err := c.SendStatus(404)
+	f.observeMiss(f.parseHost(origin.Host), c.Path())
+	f.logAccess(c, origin, nil, metrics.OutcomeNotFound, start)
+	return err
 }
 
 func cleanPath(uriPath string) string {
@@ -261,40 +463,163 @@ func formatTargetUri(format string, redirect string, uriPath string) string {
 
 }
 
+/*
+*
+Join the part of the request path kept by an entry onto the path of its redirect, leaving the
+redirect's own query and fragment where they are, with a single slash between both paths.
+*/
+func joinTargetPath(logger zerolog.Logger, redirect string, uriPath string) string {
+	usePath := cleanPath(uriPath)
+	if usePath == "" {
+		return redirect
+	}
+
+	target, err := url.Parse(redirect)
+	if err != nil {
+		logger.Debug().Msg(fmt.Sprintf("Could not parse redirect [%s] to add the path: %v", redirect, err))
+		return formatTargetUri("%s%s", redirect, uriPath)
+	}
+
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + strings.TrimPrefix(usePath, "/")
+	target.RawPath = ""
+	return target.String()
+}
+
+/*
+*
+Apply the query mode and static params of an entry to a target uri. The incoming query is
+dropped, passed through after the target's own query, or merged with it where the target's
+values win. Static params are added last and win over both.
+*/
+func applyQuery(logger zerolog.Logger, targetURI string, entry *mapping.Entry, query string) string {
+	passQuery := query != "" && (entry.Query == mapping.QueryPass || entry.Query == mapping.QueryMerge)
+	if !passQuery && len(entry.Params) == 0 {
+		return targetURI
+	}
+
+	target, err := url.Parse(targetURI)
+	if err != nil {
+		logger.Debug().Msg(fmt.Sprintf("Could not parse target [%s] to add the query: %v", targetURI, err))
+		return targetURI
+	}
+
+	switch {
+	case passQuery && entry.Query == mapping.QueryPass:
+		if target.RawQuery == "" {
+			target.RawQuery = query
+		} else {
+			target.RawQuery = target.RawQuery + "&" + query
+		}
+	case passQuery && entry.Query == mapping.QueryMerge:
+		merged, _ := url.ParseQuery(query)
+		own, _ := url.ParseQuery(target.RawQuery)
+		for name, values := range own {
+			merged[name] = values
+		}
+		target.RawQuery = merged.Encode()
+	}
+
+	if len(entry.Params) > 0 {
+		values, _ := url.ParseQuery(target.RawQuery)
+		for name, value := range entry.Params {
+			values.Set(name, value)
+		}
+		target.RawQuery = values.Encode()
+	}
+
+	return target.String()
+}
+
+// Resolution is the outcome of resolving a requested host, path and query against the mappings file
+type Resolution struct {
+	Host   string `json:"host"`   // host key of the mapping which matched
+	Path   string `json:"path"`   // path key of the entry which matched
+	Mode   string `json:"mode"`   // ModeFriendly or ModeImmediate
+	Status int    `json:"status"` // status code the response is sent with
+	Target string `json:"target"` // uri the client is sent to
+}
+
+/*
+*
+Resolve a request the way the server answers it, finding the mapping entry and building the
+target uri from it. Returns an error when no mapping entry matches. Events are written to `logger`.
+*/
+func resolveRequest(logger zerolog.Logger, mappingFile *mapping.MappingsFile, host string, uri string, query string) (*Resolution, error) {
+	if mappingFile == nil {
+		return nil, fmt.Errorf("no mapping file loaded to resolve [%s%s]", host, uri)
+	}
+	match, err := mappingFile.Match(host, uri)
+	if err != nil {
+		logger.Debug().Msg(err.Error())
+		return nil, err
+	}
+
+	resolution := &Resolution{
+		Host:   match.Host,
+		Path:   match.Path,
+		Mode:   ModeFriendly,
+		Status: 200,
+		Target: applyQuery(logger, joinTargetPath(logger, match.Redirect, match.TargetPath(uri)), match.Entry, query),
+	}
+
+	if match.Entry.Immediate {
+		resolution.Mode = ModeImmediate
+		resolution.Status = match.RedirectStatus()
+	} else if match.Status != 0 {
+		// a configured status is sent with the page, without a Location header browsers still show it
+		resolution.Status = match.Status
+	}
+
+	return resolution, nil
+}
+
 func (f *FastServer) index(c *fiber.Ctx) error {
+	start := time.Now()
 	c.Set("Content-Type", "text/html")
+	requestID, logger := f.requestID(c)
 
-	host := f.parseHost(c.Hostname())
+	// match on the host the client asked for, a trusted proxy may have rewritten the Host header
+	origin := f.origin(c)
+	if upgraded, err := f.upgrade(c, origin, start); upgraded {
+		return err
+	}
+	host := f.parseHost(origin.Host)
 	uri := string(c.Request().URI().Path())
-	remoteAddr := c.IP()
-	userAgent := c.Get("User-Agent")
-	scheme := string(c.Request().URI().Scheme())
-	mappingEntry, err := f.MappingFile.GetMappingEntry(host, uri)
+	query := string(c.Request().URI().QueryString())
+	mappingFile := f.MappingFile()
+	resolution, err := resolveRequest(logger, mappingFile, host, uri, query)
 
 	// Can't find, return 404
 	if err != nil {
-		log.Info().Msg(fmt.Sprintf("Request not found for [%s%s], remote client [%s] with user-agent: [%s]",
-			host, uri, remoteAddr, userAgent,
-		))
+		// only label known hosts, anything else is whatever the client put in the Host header
+		mappedHost := ""
+		if mappingFile != nil {
+			mappedHost, _ = mappingFile.ResolveHost(host)
+		}
 		// No content, just hang up with a http code right now.
-		return c.SendStatus(404)
+		err := c.SendStatus(404)
+		f.observeRequest(mappedHost, "", metrics.OutcomeNotFound, start)
+		f.observeMiss(host, uri)
+		f.logAccess(c, origin, nil, metrics.OutcomeNotFound, start)
+		return err
 	}
 
-	if mappingEntry.Immediate {
-		log.Info().Msg(fmt.Sprintf("Redirecting directly to [%s%s] from [%s://%s%s] for remote client [%s] with user-agent: [%s]",
-			mappingEntry.Redirect, uri, scheme, c.Hostname(), uri, remoteAddr, userAgent,
-		))
-
-		targetURI := formatTargetUri("%s%s", mappingEntry.Redirect, uri)
-		statusCode := 302
-		return c.Redirect(targetURI, statusCode) //nolint
+	if resolution.Mode == ModeImmediate {
+		err := c.Redirect(resolution.Target, resolution.Status) //nolint
+		f.observeRequest(resolution.Host, resolution.Path, metrics.OutcomeImmediate, start)
+		f.observeHit(resolution.Host, resolution.Path, start)
+		f.logAccess(c, origin, resolution, metrics.OutcomeImmediate, start)
+		return err
 	}
 
-	log.Info().Msg(fmt.Sprintf("Friendly redirect to [%s%s] from [%s://%s%s] for remote client [%s] with user-agent: [%s]",
-		mappingEntry.Redirect, uri, scheme, c.Hostname(), uri, remoteAddr, userAgent,
-	))
-	data := NewTemplateData(mappingEntry.Redirect)
-	return c.Render("html", data)
+	c.Status(resolution.Status)
+	data := NewTemplateData(resolution.Target)
+	data.RequestID = requestID
+	err = c.Render("html", data)
+	f.observeRequest(resolution.Host, resolution.Path, metrics.OutcomeFriendly, start)
+	f.observeHit(resolution.Host, resolution.Path, start)
+	f.logAccess(c, origin, resolution, metrics.OutcomeFriendly, start)
+	return err
 }
 
 func (f *FastServer) parseHost(host string) string {
@@ -305,6 +630,26 @@ func (f *FastServer) parseHost(host string) string {
 	return host
 }
 
+/*
+*
+Guard a route only answered for requests made with the host `localhost`, the Host header as sent,
+forwarded hosts are never trusted here. With `loopback` the client must also connect from the
+loopback interface, directly or through a trusted proxy, since anyone can send `Host: localhost`.
+Any other request goes on to the next route, so the mappings of its host still apply.
+*/
+func (f *FastServer) localOnly(loopback bool, handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if f.parseHost(c.Hostname()) != "localhost" {
+			return c.Next()
+		}
+		if loopback && (!c.Context().RemoteIP().IsLoopback() || !net.ParseIP(f.origin(c).IP).IsLoopback()) {
+			return c.Next()
+		}
+
+		return handler(c)
+	}
+}
+
 /*
 *
 Bootstrap routes
@@ -317,43 +662,63 @@ func (f *FastServer) setup() *fiber.App {
 		ServerHeader: "PlanetVegeta",
 		//ProxyHeader: "X-Forwarded-For",
 		GETOnly:               true,
+		IdleTimeout:           idleTimeout(f.Config.ShutdownTimeout),
 		DisableStartupMessage: f.Config.PerformanceMode, // only show banner during perf mode so we can see ps and pid IDs
 	})
 
 	server.Use(favicon.New())
 
 	server.Get("/favicon", f.notfound)
-	server.Get("/healthy", f.healthy)
-	server.Get("/metrics", f.metrics)
+	server.Get("/healthy", f.localOnly(false, f.healthy))
+	server.Get("/livez", f.localOnly(false, f.livez))
+	server.Get("/readyz", f.localOnly(false, f.readyz))
+	server.Get("/metrics", f.localOnly(false, f.metrics))
+	server.Get(MissesRoute, f.localOnly(true, f.missesReport))
+	server.Get(HitsRoute, f.localOnly(true, f.hitsReport))
+	if f.acme != nil {
+		server.Get(ACMEChallengeRoute+"*", f.acmeChallenge())
+	}
 	server.Get("/*", f.index)
 
 	f.server = server
 	return server
 }
 
-// Serve will serve the FastServer on the user defined `port`.
+// Serve will serve the FastServer on the user defined `port`, and the http port when set, until SIGINT or SIGTERM shuts it down.
 func (f *FastServer) Serve() error {
 	server := f.setup()
-	port := f.Config.Port
 
-	if f.Config.UseHTTP {
-		if err := server.Listen(fmt.Sprintf(":%d", port)); err != nil {
-			return err
-		}
-	} else {
-		if err := server.ListenTLS(fmt.Sprintf(":%d", port),
-			f.Config.ServerCert,
-			f.Config.ServerKey); err != nil {
-			return err
-		}
+	go f.watchMappingFile(f.Config.WatchInterval, f.stop)
+	if f.CertStore() != nil {
+		go f.watchCerts(f.Config.WatchInterval, f.stop)
+	}
+	if f.Config.HitsFile != "" {
+		go f.persistHits(f.Config.HitsInterval, f.stop)
+		defer f.saveHits() // the last hits, counted since the last save
 	}
 
-	return nil
+	return f.serveUntilSignalled(func() error {
+		return f.listen(server)
+	})
 }
 
 // NewFastServer factory generates a new FastServer
 func NewFastServer(config *Config, mappingFile *mapping.MappingsFile) *FastServer {
-	return &FastServer{config, mappingFile, fiber.New()}
+	fastServer := &FastServer{
+		Config:             config,
+		PrometheusExporter: metrics.NewExporter(),
+		Misses:             misses.NewTracker(config.MissCapacity),
+		Hits:               hits.NewCounter(),
+		server:             fiber.New(),
+		stop:               make(chan struct{}),
+	}
+	fastServer.swapMappingFile(mappingFile)
+	fastServer.swapCertStore(config.Certs)
+	if config.UseACME {
+		fastServer.acme = fastServer.newACMEManager()
+	}
+
+	return fastServer
 }
 
 func createServer(c *cli.Context) *FastServer {
@@ -366,12 +731,25 @@ func createServer(c *cli.Context) *FastServer {
 	// config.SetTemplateFromFile(c.String("template"))
 	config.setMappingFile(c.String("file"))
 	config.setPort(c.Int("port"))
+	config.setHTTPListener(c.Int("http-port"), c.Int("upgrade-port"))
+	config.setACME(c.Bool("acme"), c.String("acme-directory"), c.String("acme-cache"), c.String("acme-email"), c.String("acme-ca"))
+	config.setCerts(c.String("cert-dir"))
+	config.setWatchInterval(c.Duration("watch-interval"))
+	config.setTestMappings(c.Bool("test-mappings"))
+	config.setShutdown(c.Duration("drain-period"), c.Duration("shutdown-timeout"))
+	config.setProxies(c.StringSlice("trusted-proxies"), c.String("proxy-header"))
+	config.setAccessLog(c.String("access-log-format"), c.String("access-log-file"))
+	config.setRequestIDHeader(c.String("request-id-header"))
+	config.setMissCapacity(c.Int("miss-capacity"))
+	config.setHits(c.String("hits-file"), c.Duration("hits-interval"))
 
 	log.Info().Msg(fmt.Sprintf("Loaded mappings for [%d] host(s).", len(config.MappingsFile.Mappings)))
 	log.Info().Msg(fmt.Sprintf("Running server on port [%d].", config.Port))
 	//fmt.Printf("%s", config.MappingsFile.Mappings)
 
 	server := NewFastServer(config, config.MappingsFile)
+	server.PrometheusExporter.ObserveMappingLoad(metrics.LoadStartup, true)
+	server.loadHits()
 
 	return server
 }
@@ -422,12 +800,272 @@ func getAppCommands() []cli.Command {
 					Value:  DefaultServerKey,
 					Usage:  "Server Key to use when TLS mode is enabled",
 				},
+				cli.StringFlag{
+					Name:   "cert-dir",
+					EnvVar: CertDir,
+					Usage:  "directory of certificate and key pairs (name.pem or name.crt with name.key) selected by SNI, the server cert is the default",
+				},
+				cli.BoolFlag{
+					Name:   "acme",
+					EnvVar: ACME,
+					Usage:  "obtain and renew certificates for the mapped hosts from an acme directory instead of loading cert files",
+				},
+				cli.StringFlag{
+					Name:   "acme-directory",
+					EnvVar: ACMEDirectory,
+					Value:  DefaultACMEDirectory,
+					Usage:  "directory url of the acme server, e.g. a local Pebble for testing",
+				},
+				cli.StringFlag{
+					Name:   "acme-cache",
+					EnvVar: ACMECache,
+					Value:  DefaultACMECache,
+					Usage:  "directory obtained certificates and the acme account key are cached in",
+				},
+				cli.StringFlag{
+					Name:   "acme-email",
+					EnvVar: ACMEEmail,
+					Usage:  "contact email of the acme account",
+				},
+				cli.StringFlag{
+					Name:   "acme-ca",
+					EnvVar: ACMECA,
+					Usage:  "pem file of CA certificates trusted for the acme directory, the system roots by default",
+				},
+				cli.DurationFlag{
+					Name:   "watch-interval",
+					EnvVar: WatchInterval,
+					Value:  DefaultWatchInterval,
+					Usage:  "how often the mapping file and certificates are checked for changes, 0 disables watching (SIGHUP always reloads)",
+				},
+				cli.BoolFlag{
+					Name:   "test-mappings",
+					EnvVar: TestMappings,
+					Usage:  "run the tests of the mapping file on startup and reload, refusing a file whose tests fail",
+				},
+				cli.DurationFlag{
+					Name:   "drain-period",
+					EnvVar: DrainPeriod,
+					Value:  DefaultDrainPeriod,
+					Usage:  "how long health checks fail on SIGINT/SIGTERM before the server stops accepting connections",
+				},
+				cli.DurationFlag{
+					Name:   "shutdown-timeout",
+					EnvVar: ShutdownTimeout,
+					Value:  DefaultShutdownTimeout,
+					Usage:  "how long in-flight requests are given to finish on shutdown",
+				},
+				cli.StringSliceFlag{
+					Name:   "trusted-proxies",
+					EnvVar: TrustedProxies,
+					Usage:  "addresses or CIDRs of proxies whose proxy header is trusted, comma separated in the env var",
+				},
+				cli.StringFlag{
+					Name:   "proxy-header",
+					EnvVar: ProxyHeader,
+					Value:  DefaultProxyHeader,
+					Usage:  fmt.Sprintf("header trusted proxies report the client with, one of %s, %s or %s", HeaderXForwardedFor, HeaderXRealIP, HeaderForwarded),
+				},
+				cli.StringFlag{
+					Name:   "access-log-format",
+					EnvVar: AccessLogFormat,
+					Value:  accesslog.FormatJSON,
+					Usage:  fmt.Sprintf("format of the access log, one of %s", strings.Join(accesslog.Formats, ", ")),
+				},
+				cli.StringFlag{
+					Name:   "access-log-file",
+					EnvVar: AccessLogFile,
+					Usage:  "write the access log to this file rather than with the application log",
+				},
+				cli.StringFlag{
+					Name:   "request-id-header",
+					EnvVar: RequestIDHeader,
+					Value:  DefaultRequestIDHeader,
+					Usage:  "header request ids are read from and echoed in",
+				},
+				cli.IntFlag{
+					Name:   "miss-capacity",
+					EnvVar: MissCapacity,
+					Value:  DefaultMissCapacity,
+					Usage:  "number of missed hosts and paths tracked for the misses report, 0 disables tracking",
+				},
+				cli.IntFlag{
+					Name:   "http-port",
+					EnvVar: HTTPPort,
+					Usage:  "also serve plain http on this port next to tls, upgrading or serving per host",
+				},
+				cli.IntFlag{
+					Name:   "upgrade-port",
+					EnvVar: UpgradePort,
+					Value:  DefaultUpgradePort,
+					Usage:  "public https port plain http requests are upgraded to",
+				},
+				cli.StringFlag{
+					Name:   "hits-file",
+					EnvVar: HitsFile,
+					Usage:  "persist the hits of each mapping entry to this file, so they survive restarts",
+				},
+				cli.DurationFlag{
+					Name:   "hits-interval",
+					EnvVar: HitsInterval,
+					Value:  DefaultHitsInterval,
+					Usage:  "interval between saves of the hits file",
+				},
 			},
 			Action: func(c *cli.Context) error {
 				server := createServer(c)
 				return server.Serve()
 			},
 		},
+		{
+			Name:      "validate",
+			Aliases:   []string{"v"},
+			Usage:     "validate mapping files, exiting non-zero if any are invalid",
+			ArgsUsage: "[mapping files...]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "log-level, l",
+					Value: "error",
+					Usage: "Log level while validating",
+				},
+				cli.StringFlag{
+					Name:   "file, f",
+					EnvVar: MappingPath,
+					Value:  DefaultMappingPath,
+					Usage:  "Mapping file to validate when none are given as arguments",
+				},
+				cli.BoolFlag{
+					Name:  "test",
+					Usage: "run the tests of each valid mapping file",
+				},
+			},
+			Action: validateAction,
+		},
+		{
+			Name:      "test",
+			Aliases:   []string{"t"},
+			Usage:     "validate mapping files and run their tests, exiting non-zero if any fail",
+			ArgsUsage: "[mapping files...]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "log-level, l",
+					Value: "error",
+					Usage: "Log level while testing",
+				},
+				cli.StringFlag{
+					Name:   "file, f",
+					EnvVar: MappingPath,
+					Value:  DefaultMappingPath,
+					Usage:  "Mapping file to test when none are given as arguments",
+				},
+			},
+			Action: testAction,
+		},
+		{
+			Name:      "resolve",
+			Usage:     "show where urls redirect to, without running the server",
+			ArgsUsage: "<url> [urls...]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "log-level, l",
+					Value: "error",
+					Usage: "Log level while resolving",
+				},
+				cli.StringFlag{
+					Name:   "file, f",
+					EnvVar: MappingPath,
+					Value:  DefaultMappingPath,
+					Usage:  "Use the mapping file specified",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "write one json object per url",
+				},
+			},
+			Action: resolveAction,
+		},
+		{
+			Name:  "misses",
+			Usage: "show the most missed hosts and paths of a running server",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "server, s",
+					Value: DefaultMissesServer,
+					Usage: "address of the running server on this machine, the default is tls on the default port, e.g. http://127.0.0.1:8080 in http mode",
+				},
+				cli.IntFlag{
+					Name:  "limit, n",
+					Value: 20,
+					Usage: "number of misses shown, 0 shows every miss tracked",
+				},
+				cli.BoolFlag{
+					Name:  "insecure",
+					Usage: "skip verifying the certificate of a tls server",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "write the report as json",
+				},
+			},
+			Action: missesAction,
+		},
+		{
+			Name:  "gen-cert",
+			Usage: "generate a self signed development certificate, or one signed by a local ca, for every mapped host",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "log-level, l",
+					Value: "error",
+					Usage: "Log level while loading the mapping file",
+				},
+				cli.StringFlag{
+					Name:   "file, f",
+					EnvVar: MappingPath,
+					Value:  DefaultMappingPath,
+					Usage:  "Mapping file whose hosts the certificate is valid for",
+				},
+				cli.StringSliceFlag{
+					Name:  "host",
+					Usage: "extra host name or ip address the certificate is valid for, repeatable",
+				},
+				cli.StringFlag{
+					Name:   "cert",
+					EnvVar: ServerCert,
+					Value:  DefaultServerCert,
+					Usage:  "where the certificate is written",
+				},
+				cli.StringFlag{
+					Name:   "key",
+					EnvVar: ServerKey,
+					Value:  DefaultServerKey,
+					Usage:  "where the key of the certificate is written",
+				},
+				cli.DurationFlag{
+					Name:  "valid-for",
+					Value: DefaultCertValidity,
+					Usage: "how long the certificate is valid for",
+				},
+				cli.BoolFlag{
+					Name:  "ca",
+					Usage: "sign the certificate with a local ca, created the first time, instead of self signing it",
+				},
+				cli.StringFlag{
+					Name:  "ca-cert",
+					Value: DefaultCACert,
+					Usage: "where the local ca is read from or written",
+				},
+				cli.StringFlag{
+					Name:  "ca-key",
+					Value: DefaultCAKey,
+					Usage: "where the key of the local ca is read from or written",
+				},
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "replace an existing certificate and key",
+				},
+			},
+			Action: genCertAction,
+		},
 	}
 
 	return commands
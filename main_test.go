@@ -6,8 +6,11 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli"
 	"go-redirector/errors"
+	"go-redirector/mapping"
+	"io/ioutil"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -355,6 +358,112 @@ func Test_FastServerRedirectMappedRoute(t *testing.T) {
 	}
 }
 
+/*
+*
+Requests served are counted in the metrics exposition.
+*/
+func Test_FastServerMetrics(t *testing.T) {
+	testFile := "./tests/test-redirect-map.yml"
+
+	config := NewConfig()
+	config.setMappingFile(testFile)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+
+	for _, target := range []string{"/my-path", "/direct", "/missing"} {
+		request := httptest.NewRequest("GET", target, nil)
+		request.Host = "testhost"
+		if _, err := fastServer.server.Test(request); err != nil {
+			t.Errorf("Did not expect to get an error testing target [%s], error: %v", target, err)
+		}
+	}
+
+	request := httptest.NewRequest("GET", "/metrics", nil)
+	request.Host = "localhost"
+	resp, err := fastServer.server.Test(request)
+	if err != nil {
+		t.Fatalf("Did not expect to get an error testing target [/metrics], error: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	for _, expected := range []string{
+		`redirector_requests_total{host="testhost",path="/my-path",outcome="friendly"} 1`,
+		`redirector_requests_total{host="testhost",path="/direct",outcome="immediate"} 1`,
+		`redirector_requests_total{host="testhost",path="",outcome="not_found"} 1`,
+		"redirector_mapping_hosts 1",
+		"redirector_mapping_entries 3",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected to find [%s] in the metrics, got:\n%s", expected, body)
+		}
+	}
+}
+
+/*
+*
+Status codes are set per host and per entry in the status test mapping file.
+*/
+func Test_FastServerRedirectStatus(t *testing.T) {
+	testFile := "./tests/status-redirect-map.yml"
+
+	config := NewConfig()
+	config.setMappingFile(testFile)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+
+	testData := []struct {
+		target             string
+		expectedStatusCode int
+	}{
+		{"/moved", 301}, // from the host
+		{"/form", 307},  // from the entry
+		{"/page", 301},  // friendly pages carry the status too
+	}
+
+	for _, testEntry := range testData {
+		request := httptest.NewRequest("GET", testEntry.target, nil)
+		request.Host = "statushost"
+
+		if resp, err := fastServer.server.Test(request); err != nil {
+			t.Errorf("Did not expect to get an error testing target [%s], error: %v", testEntry.target, err)
+		} else if resp.StatusCode != testEntry.expectedStatusCode {
+			t.Errorf("expected [%d], got [%d] for [%s]", testEntry.expectedStatusCode, resp.StatusCode, testEntry.target)
+		}
+	}
+}
+
+/*
+*
+Path modes apply to both immediate and friendly redirects.
+*/
+func Test_FastServerPathModes(t *testing.T) {
+	testFile := "./tests/path-redirect-map.yml"
+
+	config := NewConfig()
+	config.setMappingFile(testFile)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+
+	request := httptest.NewRequest("GET", "/docs/guide?page=2", nil)
+	request.Host = "pathhost"
+	if resp, err := fastServer.server.Test(request); err != nil {
+		t.Errorf("Did not expect to get an error testing target [/docs/guide], error: %v", err)
+	} else if location := resp.Header.Get("Location"); location != "https://localhost:8081/manual/guide?page=2" {
+		t.Errorf("Expected the stripped path and query in the location, got [%s]", location)
+	}
+
+	request = httptest.NewRequest("GET", "/blog/2021/post", nil)
+	request.Host = "pathhost"
+	if resp, err := fastServer.server.Test(request); err != nil {
+		t.Errorf("Did not expect to get an error testing target [/blog/2021/post], error: %v", err)
+	} else {
+		body, _ := ioutil.ReadAll(resp.Body)
+		if !strings.Contains(string(body), "https://localhost:8082/blog/2021/post") {
+			t.Errorf("Expected the friendly page to link the appended path, got:\n%s", body)
+		}
+	}
+}
+
 func Test_CreateServer(t *testing.T) {
 	// Bare minimum required
 	fl := cli.StringFlag{
@@ -390,6 +499,26 @@ func Test_GetAppCommands(t *testing.T) {
 		"performance-mode",
 		"cert",
 		"key",
+		"cert-dir",
+		"acme",
+		"acme-directory",
+		"acme-cache",
+		"acme-email",
+		"acme-ca",
+		"watch-interval",
+		"test-mappings",
+		"drain-period",
+		"shutdown-timeout",
+		"trusted-proxies",
+		"proxy-header",
+		"access-log-format",
+		"access-log-file",
+		"request-id-header",
+		"miss-capacity",
+		"http-port",
+		"upgrade-port",
+		"hits-file",
+		"hits-interval",
 	}
 
 	if len(flags) != len(expectedFlags) {
@@ -526,3 +655,82 @@ func Test_formatTargetUri(t *testing.T) {
 		}
 	}
 }
+
+func Test_applyQuery(t *testing.T) {
+	utm := map[string]string{"utm_source": "redirector"}
+
+	testData := []struct {
+		target   string
+		entry    mapping.Entry
+		query    string
+		expected string
+	}{
+		{"https://one.example.org/page", mapping.Entry{}, "id=1", "https://one.example.org/page"},
+		{"https://two.example.org/page?a=1", mapping.Entry{Query: mapping.QueryDrop}, "id=1", "https://two.example.org/page?a=1"},
+		{"https://three.example.org/page", mapping.Entry{Query: mapping.QueryPass}, "id=1&id=2", "https://three.example.org/page?id=1&id=2"},
+		{"https://four.example.org/page?a=1", mapping.Entry{Query: mapping.QueryPass}, "a=2", "https://four.example.org/page?a=1&a=2"},
+		{"https://five.example.org/page?a=1#top", mapping.Entry{Query: mapping.QueryPass}, "b=2", "https://five.example.org/page?a=1&b=2#top"},
+		{"https://six.example.org/page?a=1&b=1", mapping.Entry{Query: mapping.QueryMerge}, "a=2&c=3", "https://six.example.org/page?a=1&b=1&c=3"},
+		{"https://seven.example.org/page", mapping.Entry{Query: mapping.QueryMerge}, "", "https://seven.example.org/page"},
+		{"https://eight.example.org/page", mapping.Entry{Params: utm}, "id=1", "https://eight.example.org/page?utm_source=redirector"},
+		{"https://nine.example.org/page", mapping.Entry{Query: mapping.QueryMerge, Params: utm}, "utm_source=other&id=1", "https://nine.example.org/page?id=1&utm_source=redirector"},
+	}
+
+	for _, testEntry := range testData {
+		entry := testEntry.entry
+		if actual := applyQuery(zerolog.Nop(), testEntry.target, &entry, testEntry.query); actual != testEntry.expected {
+			t.Errorf("Expected target to be [%s], got [%s]", testEntry.expected, actual)
+		}
+	}
+}
+
+func Test_resolveRequest(t *testing.T) {
+	mappingFile, err := mapping.LoadMappingFile("./tests/status-redirect-map.yml")
+	if err != nil {
+		t.Fatalf("Could not load test mapping file: %v", err)
+	}
+
+	if resolution, err := resolveRequest(zerolog.Nop(), mappingFile, "statushost", "/moved", "id=1"); err != nil {
+		t.Errorf("Expected [/moved] to resolve, error: %v", err)
+	} else if resolution.Mode != ModeImmediate || resolution.Status != 301 || resolution.Target != "https://localhost:8081/moved" {
+		t.Errorf("Unexpected resolution for [/moved]: %+v", resolution)
+	}
+
+	if resolution, err := resolveRequest(zerolog.Nop(), mappingFile, "statushost", "/page", ""); err != nil {
+		t.Errorf("Expected [/page] to resolve, error: %v", err)
+	} else if resolution.Mode != ModeFriendly || resolution.Status != 301 || resolution.Target != "https://localhost:8083" {
+		t.Errorf("Unexpected resolution for [/page]: %+v", resolution)
+	}
+
+	if _, err := resolveRequest(zerolog.Nop(), mappingFile, "otherhost", "/page", ""); err == nil {
+		t.Errorf("Expected an unmapped host not to resolve")
+	}
+	if _, err := resolveRequest(zerolog.Nop(), nil, "statushost", "/page", ""); err == nil {
+		t.Errorf("Expected nothing to resolve without a mapping file")
+	}
+}
+
+func Test_resolveRequestPath(t *testing.T) {
+	mappingFile, err := mapping.LoadMappingFile("./tests/path-redirect-map.yml")
+	if err != nil {
+		t.Fatalf("Could not load test mapping file: %v", err)
+	}
+
+	// the path goes onto the redirect's path, never into its query or after its fragment
+	testData := []struct {
+		uri      string
+		query    string
+		expected string
+	}{
+		{"/v1/x/y", "lang=en", "https://docs.example.org/v2/x/y?lang=en&ref=old&utm_source=legacy&x=1#top"},
+		{"/v1", "", "https://docs.example.org/v2/?ref=old&utm_source=legacy&x=1#top"},
+		{"/docs/a b", "", "https://localhost:8081/manual/a%20b"},
+	}
+	for _, testEntry := range testData {
+		if resolution, err := resolveRequest(zerolog.Nop(), mappingFile, "pathhost", testEntry.uri, testEntry.query); err != nil {
+			t.Errorf("Expected [%s] to resolve, error: %v", testEntry.uri, err)
+		} else if resolution.Target != testEntry.expected {
+			t.Errorf("Expected [%s] for [%s], got [%s]", testEntry.expected, testEntry.uri, resolution.Target)
+		}
+	}
+}
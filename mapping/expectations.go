@@ -0,0 +1,58 @@
+package mapping
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Expectation is a request listed under `tests:` along with how the mapping file must answer it
+type Expectation struct {
+	URL    string `yaml:"url"`
+	Target string `yaml:"target,omitempty"` // uri the client must be sent to, not checked when empty
+	Status int    `yaml:"status,omitempty"` // status code of the response, 404 expects no entry to match
+	Mode   string `yaml:"mode,omitempty"`   // `friendly` or `immediate`, not checked when empty
+}
+
+// expectedModes are the modes an expectation may ask for, they match the modes the server answers with
+var expectedModes = map[string]bool{"friendly": true, "immediate": true}
+
+// validateExpectation checks an expectation can be run, not whether it passes
+func validateExpectation(expectation *Expectation) []error {
+	var errs []error
+	if expectation.URL == "" {
+		errs = append(errs, broken(RuleTest, "Test has no url to request"))
+	} else if _, err := url.Parse(expectation.URL); err != nil {
+		errs = append(errs, broken(RuleTest, "Test url is not valid: %v", err))
+	}
+
+	if expectation.Status != 0 && expectation.Status != 200 && expectation.Status != 404 && !redirectStatus[expectation.Status] {
+		errs = append(errs, broken(RuleTest, "Test status [%d] is never sent, expect 200, 404 or a redirect status", expectation.Status))
+	}
+
+	if expectation.Mode != "" && !expectedModes[expectation.Mode] {
+		errs = append(errs, broken(RuleTest, "Test mode [%s] is not one of [friendly, immediate]", expectation.Mode))
+	}
+
+	if expectation.Status == 404 && (expectation.Target != "" || expectation.Mode != "") {
+		errs = append(errs, broken(RuleTest, "Test expects no match with status 404, it cannot also expect a target or mode"))
+	}
+
+	return errs
+}
+
+// expectationProblems validates every test, attributing the problems found to the test url
+func (m *MappingsFile) expectationProblems() []Problem {
+	var problems []Problem
+	for i := range m.Tests {
+		for _, err := range validateExpectation(&m.Tests[i]) {
+			problem := problemOf("", "", err)
+			problem.Test = m.Tests[i].URL
+			if problem.Test == "" {
+				problem.Test = fmt.Sprintf("#%d", i+1)
+			}
+			problems = append(problems, problem)
+		}
+	}
+
+	return problems
+}
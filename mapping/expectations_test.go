@@ -0,0 +1,82 @@
+package mapping
+
+import (
+	"testing"
+)
+
+func Test_ParseTests(t *testing.T) {
+	mappingsFile, err := LoadMappingFile("../tests/tested-redirect-map.yml")
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	if len(mappingsFile.Tests) != 4 {
+		t.Fatalf("Expected [4] tests, got [%d]", len(mappingsFile.Tests))
+	}
+
+	expected := Expectation{
+		URL:    "https://testedhost/docs/install?lang=en",
+		Target: "https://localhost:8081/manual/install",
+		Status: 302,
+		Mode:   "immediate",
+	}
+	if mappingsFile.Tests[0] != expected {
+		t.Errorf("Expected first test [%+v], got [%+v]", expected, mappingsFile.Tests[0])
+	}
+}
+
+func Test_ValidateExpectation(t *testing.T) {
+	testData := []struct {
+		expectation Expectation
+		errors      int
+	}{
+		{Expectation{URL: "https://a.example.org/", Target: "https://b.example.org"}, 0},
+		{Expectation{URL: "https://a.example.org/", Status: 404}, 0},
+		{Expectation{URL: "https://a.example.org/", Status: 308, Mode: "immediate"}, 0},
+		{Expectation{Target: "https://b.example.org"}, 1},
+		{Expectation{URL: "https://a.example.org/%zz"}, 1},
+		{Expectation{URL: "https://a.example.org/", Status: 500}, 1},
+		{Expectation{URL: "https://a.example.org/", Mode: "fast"}, 1},
+		{Expectation{URL: "https://a.example.org/", Status: 404, Target: "https://b.example.org"}, 1},
+	}
+
+	for _, test := range testData {
+		if errs := validateExpectation(&test.expectation); len(errs) != test.errors {
+			t.Errorf("Expected [%d] error(s) for [%+v], got %v", test.errors, test.expectation, errs)
+		}
+	}
+}
+
+func Test_ValidateTestsLocated(t *testing.T) {
+	data := `---
+mapping:
+  a.example.org:
+    "/":
+      redirect: https://b.example.org
+tests:
+  - url: https://a.example.org/
+    target: https://b.example.org
+  - target: https://b.example.org
+  - url: https://a.example.org/about
+    mode: fast
+`
+	_, err := Parse([]byte(data))
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError, got: %v", err)
+	}
+
+	expected := []Problem{
+		{Test: "#2", Rule: RuleTest, Line: 9, Column: 5},
+		{Test: "https://a.example.org/about", Rule: RuleTest, Line: 10, Column: 5},
+	}
+	if len(validationErr.Problems) != len(expected) {
+		t.Fatalf("Expected [%d] problems, got: %v", len(expected), validationErr)
+	}
+	for i, problem := range validationErr.Problems {
+		problem.Message = ""
+		if problem != expected[i] {
+			t.Errorf("Expected problem [%+v], got [%+v]", expected[i], problem)
+		}
+	}
+}
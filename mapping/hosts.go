@@ -0,0 +1,140 @@
+package mapping
+
+import (
+	"sort"
+	"strings"
+)
+
+// WildcardHostPrefix marks a host key as a wildcard, e.g. `*.example.org` matches `www.example.org` and `a.b.example.org`
+const WildcardHostPrefix = "*."
+
+// hostIndex is the compiled form of the host keys, built once so a lookup does not scan every host
+type hostIndex struct {
+	exact     map[string]string // lower cased host to its host key
+	wildcards []string          // wildcard host keys, most specific first
+}
+
+// isWildcardHost reports whether a host key is a wildcard
+func isWildcardHost(host string) bool {
+	return strings.HasPrefix(host, WildcardHostPrefix)
+}
+
+// labels counts the labels of a host name
+func labels(host string) int {
+	return strings.Count(host, ".") + 1
+}
+
+func compileHosts(mappings map[string]*Mapping) *hostIndex {
+	compiled := &hostIndex{exact: make(map[string]string, len(mappings))}
+	for host := range mappings {
+		if isWildcardHost(host) {
+			compiled.wildcards = append(compiled.wildcards, host)
+		} else {
+			compiled.exact[strings.ToLower(host)] = host
+		}
+	}
+
+	// the wildcard with the most labels is the most specific, ties broken alphabetically
+	sort.Slice(compiled.wildcards, func(i, j int) bool {
+		a, b := compiled.wildcards[i], compiled.wildcards[j]
+		if labels(a) != labels(b) {
+			return labels(a) > labels(b)
+		}
+		return a < b
+	})
+
+	return compiled
+}
+
+// resolve returns the host key for a requested host, exact keys first then the most specific wildcard
+func (h *hostIndex) resolve(host string) (string, bool) {
+	host = strings.ToLower(host)
+	if key, ok := h.exact[host]; ok {
+		return key, true
+	}
+
+	for _, key := range h.wildcards {
+		suffix := strings.ToLower(strings.TrimPrefix(key, "*"))
+		if len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+/*
+*
+ResolveHost returns the host key of the mapping used for a requested host. Hosts are resolved in
+order of precedence:
+ 1. an exact host key, compared case insensitively
+ 2. the most specific wildcard host key (`*.legacy.example.org` wins over `*.example.org`)
+ 3. the default host from the file `defaults`, never used for localhost
+*/
+func (m *MappingsFile) ResolveHost(host string) (string, bool) {
+	if _, ok := m.Mappings[host]; ok {
+		return host, true
+	}
+
+	index := m.hosts
+	if index == nil {
+		index = compileHosts(m.Mappings)
+	}
+	if key, ok := index.resolve(host); ok {
+		return key, true
+	}
+
+	if m.Defaults.Host != "" && host != "localhost" {
+		if _, ok := m.Mappings[m.Defaults.Host]; ok {
+			return m.Defaults.Host, true
+		}
+	}
+
+	return "", false
+}
+
+// validateHostKey checks a host key only uses a wildcard as its entire leftmost label
+func validateHostKey(host string) error {
+	name := host
+	if isWildcardHost(host) {
+		name = strings.TrimPrefix(host, WildcardHostPrefix)
+	}
+
+	if name == "" || strings.Contains(name, "*") {
+		return broken(RuleHost, "Host [%s] may only use a wildcard as its entire leftmost label, e.g. '*.example.org'.", host)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return broken(RuleHost, "Host [%s] has an empty label.", host)
+		}
+	}
+
+	return nil
+}
+
+// hostProblems checks every host key and that no two keys describe the same hosts
+func (m *MappingsFile) hostProblems() []Problem {
+	hosts := make([]string, 0, len(m.Mappings))
+	for host := range m.Mappings {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var problems []Problem
+	seen := make(map[string]string, len(hosts))
+	for _, host := range hosts {
+		if err := validateHostKey(host); err != nil {
+			problems = append(problems, problemOf(host, "", err))
+		}
+
+		normalized := strings.ToLower(host)
+		if other, ok := seen[normalized]; ok {
+			err := broken(RuleAmbiguousHost, "Hosts [%s] and [%s] are ambiguous, host names are not case sensitive.", other, host)
+			problems = append(problems, problemOf(host, "", err))
+		}
+		seen[normalized] = host
+	}
+
+	return problems
+}
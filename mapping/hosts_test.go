@@ -0,0 +1,123 @@
+package mapping
+
+import (
+	"testing"
+)
+
+const hostsMappingFile = `---
+defaults:
+  host: fallback.example.org
+mapping:
+  www.example.org:
+    "/":
+      redirect: https://localhost:8081
+  "*.example.org":
+    "/":
+      redirect: https://localhost:8082
+  "*.legacy.example.org":
+    "/":
+      redirect: https://localhost:8083
+  fallback.example.org:
+    "/":
+      redirect: https://localhost:8084
+`
+
+func Test_ResolveHost(t *testing.T) {
+	mappingsFile, err := Parse([]byte(hostsMappingFile))
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	testData := []struct {
+		host     string
+		expected string
+	}{
+		{"www.example.org", "www.example.org"},             // exact beats wildcard
+		{"WWW.Example.org", "www.example.org"},             // host names are not case sensitive
+		{"shop.example.org", "*.example.org"},              // wildcard
+		{"a.b.example.org", "*.example.org"},               // wildcards cover any depth
+		{"app.legacy.example.org", "*.legacy.example.org"}, // most specific wildcard wins
+		{"legacy.example.org", "*.example.org"},            // a wildcard never matches its own suffix
+		{"example.org", "fallback.example.org"},            // falls through to the default
+		{"unrelated.test", "fallback.example.org"},         // as does anything else
+		{"localhost", ""},                                  // except localhost
+	}
+
+	for _, testEntry := range testData {
+		key, ok := mappingsFile.ResolveHost(testEntry.host)
+		if testEntry.expected == "" {
+			if ok {
+				t.Errorf("Expected host [%s] not to resolve, got [%s]", testEntry.host, key)
+			}
+			continue
+		}
+		if key != testEntry.expected {
+			t.Errorf("Expected host [%s] to resolve to [%s], got [%s]", testEntry.host, testEntry.expected, key)
+		}
+	}
+
+	if match, err := mappingsFile.Match("app.legacy.example.org", "/page"); err != nil {
+		t.Errorf("Expected to match a wildcard host, error: [%s]", err)
+	} else if match.Host != "*.legacy.example.org" {
+		t.Errorf("Expected match on [*.legacy.example.org], got [%s]", match.Host)
+	}
+}
+
+func Test_ResolveHostWithoutDefault(t *testing.T) {
+	mappingsFile := MappingsFile{
+		Mappings: map[string]*Mapping{
+			"*.example.org": {
+				"/": newEntry(true, "https://127.0.0.1"),
+			},
+		},
+	}
+
+	if key, ok := mappingsFile.ResolveHost("www.example.org"); !ok || key != "*.example.org" {
+		t.Errorf("Expected to resolve a wildcard without compiling, got [%s]", key)
+	}
+	if _, ok := mappingsFile.ResolveHost("www.example.com"); ok {
+		t.Errorf("Expected no host without a default")
+	}
+}
+
+func Test_BadHosts(t *testing.T) {
+	testData := map[string]string{
+		"bare wildcard":      "\"*\"",
+		"wildcard mid label": "\"w*.example.org\"",
+		"wildcard mid host":  "\"www.*.example.org\"",
+		"double wildcard":    "\"*.*.example.org\"",
+		"empty label":        "\"*..example.org\"",
+	}
+
+	for name, host := range testData {
+		testFile := "---\nmapping:\n  " + host + ":\n    \"/\":\n      redirect: https://localhost:8081\n"
+		if _, err := Parse([]byte(testFile)); err == nil {
+			t.Errorf("Expected %s [%s] to be invalid", name, host)
+		}
+	}
+
+	ambiguous := `---
+mapping:
+  "*.example.org":
+    "/":
+      redirect: https://localhost:8081
+  "*.Example.org":
+    "/":
+      redirect: https://localhost:8082
+`
+	if _, err := Parse([]byte(ambiguous)); err == nil {
+		t.Errorf("Expected hosts differing only by case to be invalid")
+	}
+
+	missingDefault := `---
+defaults:
+  host: missing.example.org
+mapping:
+  www.example.org:
+    "/":
+      redirect: https://localhost:8081
+`
+	if _, err := Parse([]byte(missingDefault)); err == nil {
+		t.Errorf("Expected a default host without a mapping to be invalid")
+	}
+}
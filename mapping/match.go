@@ -0,0 +1,199 @@
+package mapping
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// Root is the path key which catches every path not otherwise matched
+	Root = "/"
+	// Wildcard is the path key which catches every path not otherwise matched, equivalent to Root
+	Wildcard = "*"
+	// PrefixSuffix marks a path key as a prefix match, e.g. `/docs/*` matches `/docs` and everything below it
+	PrefixSuffix = "/*"
+)
+
+// Match describes the mapping entry found for a requested host and path
+type Match struct {
+	Host     string // host key of the mapping which matched
+	Path     string // path key of the entry which matched
+	Entry    *Entry
+	Redirect string // redirect of the entry, with any regex groups substituted
+	Status   int    // status code configured for the entry, its host or the file, 0 when none is
+
+	Remainder string // what is left of the request path once the matched prefix is stripped
+}
+
+// PathMode returns what to do with the request path, the entry's own `path` or else the default:
+// immediate redirects append it, friendly and regex redirects drop it.
+func (m *Match) PathMode() string {
+	switch {
+	case m.Entry.Path != "":
+		return m.Entry.Path
+	case m.Entry.Regex || !m.Entry.Immediate:
+		return PathDrop
+	default:
+		return PathAppend
+	}
+}
+
+// TargetPath returns the part of the request path to add to the redirect, according to PathMode
+func (m *Match) TargetPath(path string) string {
+	switch m.PathMode() {
+	case PathAppend:
+		return path
+	case PathStrip:
+		return m.Remainder
+	default:
+		return ""
+	}
+}
+
+// remainder strips the part of the path matched by a key, prefix keys strip their prefix while
+// root and wildcard keys strip nothing. Exact and regex keys match the whole path.
+func remainder(key string, entry *Entry, path string) string {
+	switch {
+	case entry.Regex:
+		return ""
+	case key == Root || key == Wildcard:
+		return path
+	case isPrefix(key) && key != path:
+		return strings.TrimPrefix(path, strings.TrimSuffix(key, PrefixSuffix))
+	default:
+		return ""
+	}
+}
+
+// RedirectStatus returns the status code to redirect with, DefaultStatus unless one is configured
+func (m *Match) RedirectStatus() int {
+	if m.Status != 0 {
+		return m.Status
+	}
+
+	return DefaultStatus
+}
+
+// isPrefix reports whether a path key is a prefix match
+func isPrefix(path string) bool {
+	return strings.HasSuffix(path, PrefixSuffix)
+}
+
+// routes is the compiled form of a single host mapping, built once so a lookup does not scan every entry
+type routes struct {
+	patterns []*pattern // regex path entries, ordered by key
+	prefixes []string   // prefix path keys, longest first
+}
+
+func compileRoutes(mapping *Mapping) *routes {
+	compiled := &routes{}
+	for path, entry := range *mapping {
+		if entry.Regex {
+			// invalid patterns are reported by Validate, here they simply never match
+			if re, err := compilePattern(path); err == nil {
+				compiled.patterns = append(compiled.patterns, &pattern{key: path, re: re})
+			}
+		} else if isPrefix(path) {
+			compiled.prefixes = append(compiled.prefixes, path)
+		}
+	}
+
+	sort.Slice(compiled.patterns, func(i, j int) bool {
+		return compiled.patterns[i].key < compiled.patterns[j].key
+	})
+
+	// longest prefix wins, ties broken alphabetically so the order never depends on map iteration
+	sort.Slice(compiled.prefixes, func(i, j int) bool {
+		a, b := compiled.prefixes[i], compiled.prefixes[j]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a < b
+	})
+
+	return compiled
+}
+
+// matchPrefix returns the longest prefix key covering the path, or an empty string when none do
+func (r *routes) matchPrefix(path string) string {
+	for _, key := range r.prefixes {
+		prefix := strings.TrimSuffix(key, PrefixSuffix)
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return key
+		}
+	}
+
+	return ""
+}
+
+// compile builds the lookups for every host, it must be called again whenever Mappings change
+func (m *MappingsFile) compile() {
+	m.hosts = compileHosts(m.Mappings)
+	m.routes = make(map[string]*routes, len(m.Mappings))
+	for host, mapping := range m.Mappings {
+		if mapping != nil {
+			m.routes[host] = compileRoutes(mapping)
+		}
+	}
+}
+
+// routesFor returns the compiled lookups for a host, compiling them on the fly for files not built by Parse
+func (m *MappingsFile) routesFor(host string, mapping *Mapping) *routes {
+	if compiled, ok := m.routes[host]; ok {
+		return compiled
+	}
+
+	return compileRoutes(mapping)
+}
+
+/*
+*
+Match finds the mapping entry for a particular host and path, returning which keys matched.
+The host is resolved first, see ResolveHost. Paths are then resolved in order of precedence:
+ 1. an exact path entry
+ 2. the first regex entry matching the whole path, regex entries are tried in alphabetical order
+ 3. the longest prefix entry (`/docs/*`) covering the path
+ 4. the root entry `/`
+ 5. the wildcard entry `*`
+*/
+func (m *MappingsFile) Match(requestHost string, path string) (*Match, error) {
+	host, _ := m.ResolveHost(requestHost)
+	if mapping, ok := m.Mappings[host]; ok && mapping != nil {
+		newMatch := func(key string, entry Entry, redirect string) *Match {
+			return &Match{
+				Host:      host,
+				Path:      key,
+				Entry:     &entry,
+				Redirect:  redirect,
+				Status:    m.configuredStatus(host, &entry),
+				Remainder: remainder(key, &entry, path),
+			}
+		}
+		compiled := m.routesFor(host, mapping)
+
+		if entry := mapping.Get(path); entry.Redirect != "" && !entry.Regex {
+			return newMatch(path, entry, entry.Redirect), nil
+		}
+
+		for _, p := range compiled.patterns {
+			entry := mapping.Get(p.key)
+			if redirect, ok := p.expand(entry.Redirect, path); ok {
+				return newMatch(p.key, entry, redirect), nil
+			}
+		}
+
+		for _, key := range []string{compiled.matchPrefix(path), Root, Wildcard} {
+			if key == "" {
+				continue
+			}
+			if entry := mapping.Get(key); entry.Redirect != "" {
+				return newMatch(key, entry, entry.Redirect), nil
+			}
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("Could not find host and path [%s%s]", requestHost, path))
+}
@@ -0,0 +1,158 @@
+package mapping
+
+import (
+	"testing"
+)
+
+const prefixMappingFile = `---
+mapping:
+  testhost:
+    "/docs/guide":
+      redirect: https://localhost:8081
+    "/docs/*":
+      redirect: https://localhost:8082
+    "/docs/api/*":
+      redirect: https://localhost:8083
+    "/":
+      redirect: https://localhost:8084
+`
+
+func Test_MatchPrefix(t *testing.T) {
+	mappingsFile, err := Parse([]byte(prefixMappingFile))
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	testData := []struct {
+		path     string
+		expected string
+	}{
+		{"/docs/guide", "/docs/guide"},        // exact beats prefix
+		{"/docs", "/docs/*"},                  // prefix covers itself
+		{"/docs/", "/docs/*"},                 // and its trailing slash
+		{"/docs/guide/more", "/docs/*"},       // exact entries are not prefixes
+		{"/docs/api", "/docs/api/*"},          // longest prefix wins
+		{"/docs/api/v1/users", "/docs/api/*"}, // at any depth
+		{"/docsearch", "/"},                   // prefixes only match whole segments
+		{"/other", "/"},                       // root is the fall through
+	}
+
+	for _, testEntry := range testData {
+		if match, err := mappingsFile.Match("testhost", testEntry.path); err != nil {
+			t.Errorf("Expected to match path [%s], error: [%s]", testEntry.path, err)
+		} else if match.Path != testEntry.expected {
+			t.Errorf("Expected path [%s] to match [%s], got [%s]", testEntry.path, testEntry.expected, match.Path)
+		}
+	}
+}
+
+/*
+*
+Files built in code rather than through `Parse()` have no compiled routes, lookups must still work.
+*/
+func Test_MatchPrefixWithoutCompile(t *testing.T) {
+	mappingsFile := MappingsFile{
+		Mappings: map[string]*Mapping{
+			"testhost": {
+				"/docs/*": newEntry(true, "https://127.0.0.1"),
+			},
+		},
+	}
+
+	if match, err := mappingsFile.Match("testhost", "/docs/page"); err != nil {
+		t.Errorf("Expected to match a prefix without compiling, error: [%s]", err)
+	} else if match.Path != "/docs/*" {
+		t.Errorf("Expected to match [/docs/*], got [%s]", match.Path)
+	}
+
+	if _, err := mappingsFile.Match("testhost", "/other"); err == nil {
+		t.Errorf("Expected no match outside of the prefix")
+	}
+}
+
+func Test_BadPrefixes(t *testing.T) {
+	for _, path := range []string{"/docs*", "/do*cs/", "/*/docs", "/docs/**"} {
+		mapping := Mapping{
+			path: newEntry(true, "https://127.0.0.1"),
+		}
+		if err := mapping.Validate(); err == nil {
+			t.Errorf("Expected path [%s] to be invalid", path)
+		}
+	}
+}
+
+const pathModeMappingFile = `---
+mapping:
+  testhost:
+    "/docs/*":
+      immediate: true
+      path: strip
+      redirect: https://localhost:8081/manual
+    "/blog/*":
+      path: append
+      redirect: https://localhost:8082
+    "/exact":
+      immediate: true
+      path: strip
+      redirect: https://localhost:8083
+    "/old":
+      immediate: true
+      redirect: https://localhost:8084
+    "/":
+      immediate: true
+      path: drop
+      redirect: https://localhost:8085
+    "/page":
+      redirect: https://localhost:8086
+`
+
+func Test_MatchTargetPath(t *testing.T) {
+	mappingsFile, err := Parse([]byte(pathModeMappingFile))
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	testData := []struct {
+		path         string
+		expectedMode string
+		expectedPath string
+	}{
+		{"/docs/guide/intro", PathStrip, "/guide/intro"},
+		{"/docs", PathStrip, ""},
+		{"/blog/2021/post", PathAppend, "/blog/2021/post"}, // friendly entries may append too
+		{"/exact", PathStrip, ""},                          // nothing is left of an exact path
+		{"/old", PathAppend, "/old"},                       // immediate entries append by default
+		{"/page", PathDrop, ""},                            // friendly entries drop by default
+		{"/elsewhere", PathDrop, ""},
+	}
+
+	for _, testEntry := range testData {
+		match, err := mappingsFile.Match("testhost", testEntry.path)
+		if err != nil {
+			t.Errorf("Expected to match path [%s], error: [%s]", testEntry.path, err)
+			continue
+		}
+		if mode := match.PathMode(); mode != testEntry.expectedMode {
+			t.Errorf("Expected path [%s] to use mode [%s], got [%s]", testEntry.path, testEntry.expectedMode, mode)
+		}
+		if actual := match.TargetPath(testEntry.path); actual != testEntry.expectedPath {
+			t.Errorf("Expected path [%s] to add [%s], got [%s]", testEntry.path, testEntry.expectedPath, actual)
+		}
+	}
+
+	bad := Mapping{"/": Entry{Path: "keep", Redirect: "https://localhost:8081"}}
+	if err := bad.Validate(); err == nil {
+		t.Errorf("Expected an unknown path mode to be invalid")
+	}
+}
+
+func Test_MatchStripRoot(t *testing.T) {
+	mapping := Mapping{"*": Entry{Immediate: true, Path: PathStrip, Redirect: "https://localhost:8081"}}
+	mappingsFile := MappingsFile{Mappings: map[string]*Mapping{"testhost": &mapping}}
+
+	if match, err := mappingsFile.Match("testhost", "/any/path"); err != nil {
+		t.Errorf("Expected to match the wildcard, error: [%s]", err)
+	} else if actual := match.TargetPath("/any/path"); actual != "/any/path" {
+		t.Errorf("Expected the wildcard to strip nothing, got [%s]", actual)
+	}
+}
@@ -0,0 +1,183 @@
+package mapping
+
+import (
+	"sort"
+)
+
+const (
+	// DefaultStatus is the status code used for redirects when none is configured
+	DefaultStatus = 302
+
+	// QueryDrop discards the request query, the redirect keeps its own. This is the default.
+	QueryDrop = "drop"
+	// QueryPass appends the request query to the redirect as is, after any query the redirect has
+	QueryPass = "pass"
+	// QueryMerge combines the request query with the query of the redirect, the redirect wins on conflicts
+	QueryMerge = "merge"
+
+	// PathDrop never adds the request path to the redirect. This is the default for friendly and regex redirects.
+	PathDrop = "drop"
+	// PathAppend adds the whole request path to the redirect. This is the default for immediate redirects.
+	PathAppend = "append"
+	// PathStrip adds what is left of the request path once the matched prefix is removed
+	PathStrip = "strip"
+
+	// HTTPUpgrade redirects plain http requests to https, when the server also serves https. This is the default.
+	HTTPUpgrade = "upgrade"
+	// HTTPServe answers plain http requests with the mappings, as https requests are
+	HTTPServe = "serve"
+)
+
+// redirectStatus lists the status codes an entry, host or file may redirect with
+var redirectStatus = map[int]bool{
+	301: true, // moved permanently
+	302: true, // found
+	303: true, // see other
+	307: true, // temporary redirect, keeps the method
+	308: true, // permanent redirect, keeps the method
+}
+
+func validateStatus(status int) error {
+	if status != 0 && !redirectStatus[status] {
+		return broken(RuleStatus, "Status [%d] is not a redirect, use one of 301, 302, 303, 307 or 308.", status)
+	}
+
+	return nil
+}
+
+func validateQuery(entry *Entry) error {
+	switch entry.Query {
+	case "", QueryDrop, QueryPass, QueryMerge:
+	default:
+		return broken(RuleQuery, "Query [%s] is not one of '%s', '%s' or '%s'.", entry.Query, QueryDrop, QueryPass, QueryMerge)
+	}
+
+	for name := range entry.Params {
+		if name == "" {
+			return broken(RuleQuery, "Params cannot have an empty name.")
+		}
+	}
+
+	return nil
+}
+
+func validatePathMode(entry *Entry) error {
+	switch entry.Path {
+	case "", PathDrop, PathAppend, PathStrip:
+		return nil
+	default:
+		return broken(RulePathMode, "Path [%s] is not one of '%s', '%s' or '%s'.", entry.Path, PathDrop, PathAppend, PathStrip)
+	}
+}
+
+func validateHTTPMode(mode string) error {
+	switch mode {
+	case "", HTTPUpgrade, HTTPServe:
+		return nil
+	default:
+		return broken(RuleHTTPMode, "Http [%s] is not one of '%s' or '%s'.", mode, HTTPUpgrade, HTTPServe)
+	}
+}
+
+// Defaults are settings applied to every host unless the host or entry sets its own
+type Defaults struct {
+	Host   string `yaml:"host,omitempty"` // host key whose mapping serves any host not otherwise matched
+	Status int    `yaml:"status,omitempty"`
+	HTTP   string `yaml:"http,omitempty"` // HTTPUpgrade or HTTPServe
+}
+
+// HostOptions are settings applied to every entry of a host unless the entry sets its own
+type HostOptions struct {
+	Status int    `yaml:"status,omitempty"`
+	HTTP   string `yaml:"http,omitempty"` // HTTPUpgrade or HTTPServe
+	Cert   string `yaml:"cert,omitempty"` // certificate served for the host over tls, with Key
+	Key    string `yaml:"key,omitempty"`
+}
+
+func validateCert(options *HostOptions) error {
+	if (options.Cert == "") != (options.Key == "") {
+		return broken(RuleCert, "Cert and key must be set together.")
+	}
+
+	return nil
+}
+
+// hostOptionProblems checks the options of every host
+func (m *MappingsFile) hostOptionProblems() []Problem {
+	hosts := make([]string, 0, len(m.Hosts))
+	for host := range m.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var problems []Problem
+	for _, host := range hosts {
+		if _, ok := m.Mappings[host]; !ok {
+			problems = append(problems, Problem{Host: host, Rule: RuleHostOptions, Message: "Host options are set for a host which has no mapping."})
+			continue
+		}
+		if options := m.Hosts[host]; options != nil {
+			if err := validateStatus(options.Status); err != nil {
+				problems = append(problems, problemOf(host, "", err))
+			}
+			if err := validateHTTPMode(options.HTTP); err != nil {
+				problems = append(problems, problemOf(host, "", err))
+			}
+			if err := validateCert(options); err != nil {
+				problems = append(problems, problemOf(host, "", err))
+			}
+		}
+	}
+
+	return problems
+}
+
+// defaultProblems checks the file defaults
+func (m *MappingsFile) defaultProblems() []Problem {
+	var problems []Problem
+	if err := validateStatus(m.Defaults.Status); err != nil {
+		problems = append(problems, problemOf("", "", err))
+	}
+	if err := validateHTTPMode(m.Defaults.HTTP); err != nil {
+		problems = append(problems, problemOf("", "", err))
+	}
+
+	if m.Defaults.Host != "" {
+		if _, ok := m.Mappings[m.Defaults.Host]; !ok {
+			problems = append(problems, Problem{Rule: RuleDefaultHost, Message: "Default host [" + m.Defaults.Host + "] has no mapping."})
+		}
+	}
+
+	return problems
+}
+
+// configuredStatus returns the status set for an entry, falling back to its host and then the file
+// defaults, or 0 when none of them set one
+func (m *MappingsFile) configuredStatus(host string, entry *Entry) int {
+	if entry.Status != 0 {
+		return entry.Status
+	}
+	if options, ok := m.Hosts[host]; ok && options != nil && options.Status != 0 {
+		return options.Status
+	}
+
+	return m.Defaults.Status
+}
+
+/*
+*
+HTTPMode returns how plain http requests for a host are answered when the server also serves https,
+HTTPUpgrade or HTTPServe. The host is resolved like Match does, then its options are used, falling
+back to the file defaults and finally HTTPUpgrade.
+*/
+func (m *MappingsFile) HTTPMode(requestHost string) string {
+	host, _ := m.ResolveHost(requestHost)
+	if options, ok := m.Hosts[host]; ok && options != nil && options.HTTP != "" {
+		return options.HTTP
+	}
+	if m.Defaults.HTTP != "" {
+		return m.Defaults.HTTP
+	}
+
+	return HTTPUpgrade
+}
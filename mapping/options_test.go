@@ -0,0 +1,225 @@
+package mapping
+
+import (
+	"testing"
+)
+
+const statusMappingFile = `---
+defaults:
+  status: 307
+hosts:
+  permanent.example.org:
+    status: 301
+mapping:
+  permanent.example.org:
+    "/":
+      redirect: https://localhost:8081
+    "/form":
+      status: 308
+      redirect: https://localhost:8082
+  temporary.example.org:
+    "/":
+      redirect: https://localhost:8083
+`
+
+func Test_MatchStatus(t *testing.T) {
+	mappingsFile, err := Parse([]byte(statusMappingFile))
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	testData := []struct {
+		host     string
+		path     string
+		expected int
+	}{
+		{"permanent.example.org", "/form", 308}, // entry wins
+		{"permanent.example.org", "/", 301},     // then the host
+		{"temporary.example.org", "/", 307},     // then the file defaults
+	}
+
+	for _, testEntry := range testData {
+		if match, err := mappingsFile.Match(testEntry.host, testEntry.path); err != nil {
+			t.Errorf("Expected to match [%s%s], error: [%s]", testEntry.host, testEntry.path, err)
+		} else if status := match.RedirectStatus(); status != testEntry.expected {
+			t.Errorf("Expected [%s%s] to redirect with [%d], got [%d]", testEntry.host, testEntry.path, testEntry.expected, status)
+		}
+	}
+}
+
+func Test_MatchDefaultStatus(t *testing.T) {
+	mappingsFile, err := Parse([]byte(prefixMappingFile))
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	if match, err := mappingsFile.Match("testhost", "/"); err != nil {
+		t.Errorf("Expected to match root, error: [%s]", err)
+	} else {
+		if match.Status != 0 {
+			t.Errorf("Expected no configured status, got [%d]", match.Status)
+		}
+		if match.RedirectStatus() != DefaultStatus {
+			t.Errorf("Expected the default status [%d], got [%d]", DefaultStatus, match.RedirectStatus())
+		}
+	}
+}
+
+func Test_BadStatus(t *testing.T) {
+	testFiles := []string{
+		`---
+mapping:
+  testhost:
+    "/":
+      status: 200
+      redirect: https://localhost:8081
+`,
+		`---
+defaults:
+  status: 404
+mapping:
+  testhost:
+    "/":
+      redirect: https://localhost:8081
+`,
+		`---
+hosts:
+  testhost:
+    status: 300
+mapping:
+  testhost:
+    "/":
+      redirect: https://localhost:8081
+`,
+		`---
+hosts:
+  unmapped:
+    status: 301
+mapping:
+  testhost:
+    "/":
+      redirect: https://localhost:8081
+`,
+	}
+
+	for index, testFile := range testFiles {
+		if _, err := Parse([]byte(testFile)); err == nil {
+			t.Errorf("Expected testFiles[%d] to be invalid", index)
+		}
+	}
+}
+
+func Test_BadQuery(t *testing.T) {
+	testData := []Entry{
+		{Query: "keep", Redirect: "https://localhost:8081"},
+		{Params: map[string]string{"": "value"}, Redirect: "https://localhost:8081"},
+	}
+
+	for index, entry := range testData {
+		mapping := Mapping{"/": entry}
+		if err := mapping.Validate(); err == nil {
+			t.Errorf("Expected testData[%d] to be invalid", index)
+		}
+	}
+
+	mapping := Mapping{"/": Entry{Query: QueryMerge, Params: map[string]string{"utm_source": "redirector"}, Redirect: "https://localhost:8081"}}
+	if err := mapping.Validate(); err != nil {
+		t.Errorf("Expected query and params to be valid, got: %v", err)
+	}
+}
+
+func Test_HTTPMode(t *testing.T) {
+	mappingsFile, err := Parse([]byte(`---
+defaults:
+  http: serve
+hosts:
+  secure.example.org:
+    http: upgrade
+mapping:
+  secure.example.org:
+    "/":
+      redirect: https://localhost:8081
+  "*.plain.example.org":
+    "/":
+      redirect: https://localhost:8082
+`))
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	testData := map[string]string{
+		"secure.example.org":    HTTPUpgrade, // host wins
+		"www.plain.example.org": HTTPServe,   // then the file defaults
+		"unknown.example.org":   HTTPServe,
+	}
+	for host, expected := range testData {
+		if mode := mappingsFile.HTTPMode(host); mode != expected {
+			t.Errorf("Expected [%s] for [%s], got [%s]", expected, host, mode)
+		}
+	}
+
+	if mode := NewMappingsFile().HTTPMode("testhost"); mode != HTTPUpgrade {
+		t.Errorf("Expected [%s] when nothing is set, got [%s]", HTTPUpgrade, mode)
+	}
+}
+
+func Test_BadHTTPMode(t *testing.T) {
+	testFiles := []string{
+		`---
+defaults:
+  http: redirect
+mapping:
+  testhost:
+    "/":
+      redirect: https://localhost:8081
+`,
+		`---
+hosts:
+  testhost:
+    http: https
+mapping:
+  testhost:
+    "/":
+      redirect: https://localhost:8081
+`,
+	}
+
+	for index, testFile := range testFiles {
+		_, err := Parse([]byte(testFile))
+		validationErr, ok := err.(*ValidationError)
+		if !ok || len(validationErr.Problems) != 1 || validationErr.Problems[0].Rule != RuleHTTPMode {
+			t.Errorf("Expected testFiles[%d] to break [%s], got: %v", index, RuleHTTPMode, err)
+		}
+	}
+}
+
+func Test_BadCert(t *testing.T) {
+	testFiles := []string{
+		`---
+hosts:
+  testhost:
+    cert: ./certs/testhost.pem
+mapping:
+  testhost:
+    "/":
+      redirect: https://localhost:8081
+`,
+		`---
+hosts:
+  testhost:
+    key: ./certs/testhost.key
+mapping:
+  testhost:
+    "/":
+      redirect: https://localhost:8081
+`,
+	}
+
+	for index, testFile := range testFiles {
+		_, err := Parse([]byte(testFile))
+		validationErr, ok := err.(*ValidationError)
+		if !ok || len(validationErr.Problems) != 1 || validationErr.Problems[0].Rule != RuleCert {
+			t.Errorf("Expected testFiles[%d] to break [%s], got: %v", index, RuleCert, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package mapping
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// reference finds `$1`, `$name` and `${name}` group references in a redirect, `$$` is a literal dollar sign
+var reference = regexp.MustCompile(`\$(\$|\{[^}]*\}|[A-Za-z0-9_]+)`)
+
+// pattern is a compiled regex path entry
+type pattern struct {
+	key string
+	re  *regexp.Regexp
+}
+
+// compilePattern compiles a regex path key, anchored so it must match the entire request path, anchors of the key are kept as they are
+func compilePattern(path string) (*regexp.Regexp, error) {
+	return regexp.Compile(fmt.Sprintf("^(?:%s)$", path))
+}
+
+// validatePattern checks a regex path key compiles and that the redirect only references groups it defines
+func validatePattern(path string, redirect string) error {
+	if !strings.HasPrefix(strings.TrimPrefix(path, "^"), "/") {
+		return broken(RuleRegex, "Regex path [%s] must match from the leading '/' of the path.", path)
+	}
+
+	re, err := compilePattern(path)
+	if err != nil {
+		return broken(RuleRegex, "Regex path [%s] does not compile: %v", path, err)
+	}
+
+	names := map[string]bool{}
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			names[name] = true
+		}
+	}
+
+	for _, ref := range reference.FindAllStringSubmatch(redirect, -1) {
+		name := strings.TrimSuffix(strings.TrimPrefix(ref[1], "{"), "}")
+		if name == "$" {
+			continue
+		}
+		if index, err := strconv.Atoi(name); err == nil {
+			if index > re.NumSubexp() {
+				return broken(RuleRegex, "Redirect [%s] references group [%s] but regex path [%s] only has %d group(s).", redirect, ref[0], path, re.NumSubexp())
+			}
+			continue
+		}
+		if !names[name] {
+			return broken(RuleRegex, "Redirect [%s] references group [%s] which regex path [%s] does not name.", redirect, ref[0], path)
+		}
+	}
+
+	return nil
+}
+
+// expand substitutes the groups captured from the path into the redirect
+func (p *pattern) expand(redirect string, path string) (string, bool) {
+	submatches := p.re.FindStringSubmatchIndex(path)
+	if submatches == nil {
+		return "", false
+	}
+
+	return string(p.re.ExpandString(nil, redirect, path, submatches)), true
+}
@@ -0,0 +1,87 @@
+package mapping
+
+import (
+	"testing"
+)
+
+const regexMappingFile = `---
+mapping:
+  testhost:
+    "/article/(\\d+)/[^/]+":
+      regex: true
+      redirect: https://new.example.org/a/$1
+    "^/user/(?P<name>[a-z]+)$":
+      regex: true
+      redirect: https://new.example.org/people/${name}?from=$$old
+    "/price\\$":
+      regex: true
+      redirect: https://new.example.org/price
+    "/article/latest":
+      redirect: https://new.example.org/latest
+    "/article/*":
+      redirect: https://new.example.org/articles
+`
+
+func Test_MatchRegex(t *testing.T) {
+	mappingsFile, err := Parse([]byte(regexMappingFile))
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	testData := []struct {
+		path             string
+		expectedKey      string
+		expectedRedirect string
+	}{
+		{"/article/123/some-slug", `/article/(\d+)/[^/]+`, "https://new.example.org/a/123"},
+		{"/user/vegeta", "^/user/(?P<name>[a-z]+)$", "https://new.example.org/people/vegeta?from=$old"},
+		{"/article/latest", "/article/latest", "https://new.example.org/latest"},     // exact beats regex
+		{"/article/123/slug/more", "/article/*", "https://new.example.org/articles"}, // regex must match the whole path
+		{"/article/abc/slug", "/article/*", "https://new.example.org/articles"},      // groups must match
+		{"/price$", `/price\$`, "https://new.example.org/price"},                     // an escaped dollar sign is not an anchor
+		{"/price", "", ""},
+		{"/user/Vegeta", "", ""}, // no fall through
+	}
+
+	for _, testEntry := range testData {
+		match, err := mappingsFile.Match("testhost", testEntry.path)
+		if testEntry.expectedKey == "" {
+			if err == nil {
+				t.Errorf("Expected path [%s] not to match, matched [%s]", testEntry.path, match.Path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected to match path [%s], error: [%s]", testEntry.path, err)
+			continue
+		}
+		if match.Path != testEntry.expectedKey {
+			t.Errorf("Expected path [%s] to match [%s], got [%s]", testEntry.path, testEntry.expectedKey, match.Path)
+		}
+		if match.Redirect != testEntry.expectedRedirect {
+			t.Errorf("Expected path [%s] to redirect to [%s], got [%s]", testEntry.path, testEntry.expectedRedirect, match.Redirect)
+		}
+	}
+}
+
+func Test_BadRegexMappings(t *testing.T) {
+	testData := []struct {
+		path     string
+		redirect string
+	}{
+		{"/article/(\\d+", "https://new.example.org/a/$1"},             // does not compile
+		{"article/(\\d+)", "https://new.example.org/a/$1"},             // not rooted at '/'
+		{"/article/(\\d+)", "https://new.example.org/a/$2"},            // no such group number
+		{"/article/(?P<id>\\d+)", "https://new.example.org/a/${slug}"}, // no such group name
+		{"/article/(\\d+)", "http://new.example.org/a/$1"},             // still https only
+	}
+
+	for _, testEntry := range testData {
+		mapping := Mapping{
+			testEntry.path: Entry{Regex: true, Redirect: testEntry.redirect},
+		}
+		if err := mapping.Validate(); err == nil {
+			t.Errorf("Expected regex path [%s] redirecting to [%s] to be invalid", testEntry.path, testEntry.redirect)
+		}
+	}
+}
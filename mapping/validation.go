@@ -0,0 +1,229 @@
+package mapping
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rules a mapping file can break, reported on each Problem
+const (
+	RuleYAML          = "yaml"           // the file is not valid yaml or does not fit the mapping file structure
+	RuleEmpty         = "empty"          // the file has no mappings
+	RuleReservedHost  = "reserved-host"  // localhost cannot be mapped
+	RuleHost          = "host"           // a host key is malformed
+	RuleAmbiguousHost = "ambiguous-host" // two host keys describe the same hosts
+	RuleDefaultHost   = "default-host"   // the default host has no mapping
+	RuleHostOptions   = "host-options"   // host options are set for a host without a mapping
+	RulePath          = "path"           // a path key is malformed
+	RuleRegex         = "regex"          // a regex path key does not compile or does not fit its redirect
+	RuleRedirect      = "redirect"       // a redirect is not a fully qualified uri
+	RuleHTTPS         = "https"          // a redirect does not use https
+	RuleStatus        = "status"         // a status is not a redirect status
+	RuleQuery         = "query"          // a query mode or param is invalid
+	RulePathMode      = "path-mode"      // a path mode is invalid
+	RuleHTTPMode      = "http-mode"      // an http mode is invalid
+	RuleCert          = "cert"           // a host sets a cert without a key, or a key without a cert
+	RuleTest          = "test"           // a test under `tests:` cannot be run
+)
+
+// Problem is a single rule broken by a mapping file, with where it was broken
+type Problem struct {
+	Host    string `json:"host,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Test    string `json:"test,omitempty"` // url of the test under `tests:`, or its number when it has none
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"` // line and column in the yaml source, 0 when unknown
+	Column  int    `json:"column,omitempty"`
+}
+
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.Line > 0 && p.Column > 0 {
+		fmt.Fprintf(&b, "line %d, column %d: ", p.Line, p.Column)
+	} else if p.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", p.Line)
+	}
+	if p.Host != "" {
+		fmt.Fprintf(&b, "host [%s] ", p.Host)
+	}
+	if p.Path != "" {
+		fmt.Fprintf(&b, "path [%s] ", p.Path)
+	}
+	if p.Test != "" {
+		fmt.Fprintf(&b, "test [%s] ", p.Test)
+	}
+	fmt.Fprintf(&b, "%s (%s)", p.Message, p.Rule)
+
+	return b.String()
+}
+
+// ValidationError lists every problem found validating a mapping file
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("%d problem(s) found in the mapping file", len(e.Problems)))
+	for _, problem := range e.Problems {
+		lines = append(lines, problem.String())
+	}
+
+	return strings.Join(lines, "\n  ")
+}
+
+// validationError returns the problems as an error, or nil when there are none
+func validationError(problems []Problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Problems: problems}
+}
+
+// ruleError is an error which knows the rule it breaks
+type ruleError struct {
+	rule string
+	msg  string
+}
+
+func (e *ruleError) Error() string {
+	return e.msg
+}
+
+func broken(rule string, format string, args ...interface{}) error {
+	return &ruleError{rule: rule, msg: fmt.Sprintf(format, args...)}
+}
+
+// problemOf turns a validation error into a problem for the host and path it was found on
+func problemOf(host string, path string, err error) Problem {
+	rule := RulePath
+	if ruleErr, ok := err.(*ruleError); ok {
+		rule = ruleErr.rule
+	}
+
+	return Problem{Host: host, Path: path, Rule: rule, Message: err.Error()}
+}
+
+// sortedKeys returns the keys of a mapping in order, so problems are always reported the same way
+func sortedKeys(m *Mapping) []string {
+	keys := make([]string, 0, len(*m))
+	for key := range *m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// nodeKey identifies a node of the mapping file, an empty host and path identify a top level section
+type nodeKey struct {
+	section string
+	host    string
+	path    string
+}
+
+type position struct {
+	line   int
+	column int
+}
+
+// positions records where the sections, hosts and paths of a mapping file are in its yaml source.
+// Each test is recorded as a path of the tests section, under both its url and its number (`#1`).
+type positions map[nodeKey]position
+
+func recordPositions(root *yaml.Node) positions {
+	found := positions{}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return found
+	}
+
+	// mapping nodes hold their keys and values side by side
+	eachPair := func(node *yaml.Node, visit func(key *yaml.Node, value *yaml.Node)) {
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			visit(node.Content[i], node.Content[i+1])
+		}
+	}
+	at := func(node *yaml.Node) position {
+		return position{line: node.Line, column: node.Column}
+	}
+
+	eachPair(root.Content[0], func(section *yaml.Node, sectionValue *yaml.Node) {
+		found[nodeKey{section: section.Value}] = at(section)
+		if sectionValue.Kind == yaml.SequenceNode {
+			for i, item := range sectionValue.Content {
+				found[nodeKey{section: section.Value, path: "#" + strconv.Itoa(i+1)}] = at(item)
+				eachPair(item, func(key *yaml.Node, value *yaml.Node) {
+					if key.Value == "url" && value.Value != "" {
+						found[nodeKey{section: section.Value, path: value.Value}] = at(item)
+					}
+				})
+			}
+			return
+		}
+		eachPair(sectionValue, func(host *yaml.Node, hostValue *yaml.Node) {
+			found[nodeKey{section: section.Value, host: host.Value}] = at(host)
+			if section.Value != "mapping" {
+				return
+			}
+			eachPair(hostValue, func(path *yaml.Node, _ *yaml.Node) {
+				found[nodeKey{section: section.Value, host: host.Value, path: path.Value}] = at(path)
+			})
+		})
+	})
+
+	return found
+}
+
+// locate sets the line and column of each problem from the most specific node known for it
+func (p positions) locate(section string, problems []Problem) {
+	for i := range problems {
+		path := problems[i].Path
+		if problems[i].Test != "" {
+			path = problems[i].Test
+		}
+		keys := []nodeKey{
+			{section, problems[i].Host, path},
+			{section, problems[i].Host, ""},
+			{section, "", ""},
+		}
+		for _, key := range keys {
+			if pos, ok := p[key]; ok {
+				problems[i].Line = pos.line
+				problems[i].Column = pos.column
+				break
+			}
+		}
+	}
+}
+
+// yamlProblems turns the errors from decoding yaml into problems, keeping their line numbers
+func yamlProblems(err error) []Problem {
+	var messages []string
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+
+	problems := make([]Problem, 0, len(messages))
+	for _, msg := range messages {
+		problem := Problem{Rule: RuleYAML, Message: msg}
+		var line int
+		if _, scanErr := fmt.Sscanf(msg, "line %d:", &line); scanErr == nil {
+			problem.Line = line
+			problem.Message = strings.TrimSpace(strings.SplitN(msg, ":", 2)[1])
+		}
+		problems = append(problems, problem)
+	}
+
+	return problems
+}
@@ -0,0 +1,88 @@
+package mapping
+
+import (
+	"strings"
+	"testing"
+)
+
+const invalidMappingFile = `---
+defaults:
+  status: 200
+mapping:
+  localhost:
+    "/":
+      redirect: https://localhost:8081
+  testhost:
+    "/ok":
+      redirect: https://localhost:8082
+    "nope":
+      status: 201
+      redirect: http://localhost:8083
+`
+
+func Test_ValidationErrorListsEveryProblem(t *testing.T) {
+	_, err := Parse([]byte(invalidMappingFile))
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError, got: %v", err)
+	}
+
+	expected := []Problem{
+		{Host: "localhost", Rule: RuleReservedHost, Line: 5, Column: 3},
+		{Host: "testhost", Path: "nope", Rule: RulePath, Line: 11, Column: 5},
+		{Host: "testhost", Path: "nope", Rule: RuleStatus, Line: 11, Column: 5},
+		{Host: "testhost", Path: "nope", Rule: RuleHTTPS, Line: 11, Column: 5},
+		{Rule: RuleStatus, Line: 2, Column: 1},
+	}
+
+	if len(validationErr.Problems) != len(expected) {
+		t.Fatalf("Expected [%d] problems, got [%d]: %v", len(expected), len(validationErr.Problems), err)
+	}
+
+	for index, problem := range validationErr.Problems {
+		want := expected[index]
+		if problem.Host != want.Host || problem.Path != want.Path || problem.Rule != want.Rule {
+			t.Errorf("Expected problem [%d] to be [%s] on host [%s] path [%s], got: %s", index, want.Rule, want.Host, want.Path, problem)
+		}
+		if problem.Line != want.Line || problem.Column != want.Column {
+			t.Errorf("Expected problem [%d] at line %d, column %d, got: %s", index, want.Line, want.Column, problem)
+		}
+	}
+
+	if !strings.Contains(err.Error(), "line 11, column 5: host [testhost] path [nope]") {
+		t.Errorf("Expected the error to render where each problem is, got: %v", err)
+	}
+}
+
+func Test_ValidationErrorFromYAML(t *testing.T) {
+	_, err := Parse([]byte("---\nmapping:\n  testhost: 1\n"))
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError, got: %v", err)
+	}
+
+	if len(validationErr.Problems) != 1 {
+		t.Fatalf("Expected [1] problem, got: %v", err)
+	}
+	if problem := validationErr.Problems[0]; problem.Rule != RuleYAML || problem.Line != 3 {
+		t.Errorf("Expected a yaml problem on line 3, got: %s", problem)
+	}
+}
+
+func Test_ValidationErrorWithoutSource(t *testing.T) {
+	mapping := Mapping{
+		"b": newEntry(true, "https://127.0.0.1"),
+		"a": newEntry(true, "https://127.0.0.1"),
+	}
+
+	validationErr, ok := mapping.Validate().(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError")
+	}
+	if len(validationErr.Problems) != 2 {
+		t.Fatalf("Expected a problem for each bad path, got: %v", validationErr)
+	}
+	if validationErr.Problems[0].Path != "a" || validationErr.Problems[0].Line != 0 {
+		t.Errorf("Expected problems in path order without positions, got: %s", validationErr.Problems[0])
+	}
+}
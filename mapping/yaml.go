@@ -1,12 +1,14 @@
 package mapping
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"github.com/juju/errors"
 	"github.com/rs/zerolog/log"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 	"io/ioutil"
 	"net/url"
+	"sort"
 	"strings"
 )
 
@@ -14,6 +16,12 @@ import (
 type Entry struct {
 	Immediate bool   `yaml:"immediate,omitempty"`
 	Redirect  string `yaml:"redirect,omitempty"`
+	Regex     bool   `yaml:"regex,omitempty"`  // the path is a regular expression, its groups may be used in the redirect
+	Status    int    `yaml:"status,omitempty"` // status code of the redirect, see DefaultStatus
+	Query     string `yaml:"query,omitempty"`  // what happens to the request query, see QueryDrop, QueryPass and QueryMerge
+	Path      string `yaml:"path,omitempty"`   // what happens to the request path, see PathDrop, PathAppend and PathStrip
+
+	Params map[string]string `yaml:"params,omitempty"` // static query params always added to the redirect
 }
 
 // Mapping is a type which is used to store mapping in the mappings file
@@ -40,8 +48,49 @@ func validStart(path string) bool {
 	return false
 }
 
-// Validate a single mapping
+// validatePath checks a plain (non regex) path key
+func validatePath(path string) error {
+	if !validStart(path) {
+		return broken(RulePath, "Redirect uri [%s] must always be prefixed with '/' or '*', no relative or empty paths accepted here.", path)
+	}
+
+	if strings.Contains(strings.TrimSuffix(path, PrefixSuffix), "*") && path != Wildcard {
+		return broken(RulePath, "Path [%s] may only use '*' on its own or as a trailing '/*' prefix match.", path)
+	}
+
+	if strings.Contains(path, "?") {
+		return broken(RulePath, "Mapping entries for path should not contain query params, only redirects can have params - path found [%s]", path)
+	}
+
+	if _, err := url.ParseRequestURI(path); err != nil {
+		return broken(RulePath, "%v", err)
+	}
+
+	return nil
+}
+
+// validateRedirect checks the redirect of an entry is a fully qualified https uri
+func validateRedirect(entry *Entry) error {
+	uri, err := url.ParseRequestURI(entry.Redirect)
+	if err != nil {
+		log.Debug().Msg("Redirect uri is not fully qualified.")
+		return broken(RuleRedirect, "%v", err)
+	}
+
+	if uri.Scheme != "https" {
+		return broken(RuleHTTPS, "Redirect uri scheme on [%s] needs to be changed and use 'https' as the scheme.", uri.String())
+	}
+
+	return nil
+}
+
+// Validate a single mapping, the error lists every problem found, see ValidationError
 func (m *Mapping) Validate() error {
+	return validationError(m.problems(""))
+}
+
+// problems validates every entry of a mapping, attributing the problems found to the host
+func (m *Mapping) problems(host string) []Problem {
 	logEntry := func(entry *Entry, path string) {
 		isFriendly := entry.Immediate
 		if isFriendly {
@@ -54,52 +103,53 @@ func (m *Mapping) Validate() error {
 	// WARNING: only return errors, leaving the last line a fall through nil. An early return
 	//          will cause issues as file reads are async you risk exiting validation earlier
 	//          than intended.
-	validate := func(entry *Entry, path string) error {
-		if !validStart(path) {
-			msg := fmt.Sprintf("Redirect uri [%s] must always be prefixed with '/' or '*', no relative or empty paths accepted here.", path)
-			log.Error().Msg(msg)
-			return errors.New(msg)
-		}
-
-		if strings.Contains(path, "?") {
-			msg := fmt.Sprintf("Mapping entries for path should not contain query params, only redirects can have params - path found [%s]", path)
-			log.Error().Msg(msg)
-			return errors.New(msg)
+	validate := func(entry *Entry, path string) []error {
+		var errs []error
+		if entry.Regex {
+			if err := validatePattern(path, entry.Redirect); err != nil {
+				errs = append(errs, err)
+			}
+		} else if err := validatePath(path); err != nil {
+			errs = append(errs, err)
 		}
 
-		if _, err := url.ParseRequestURI(path); err != nil {
-			return err
+		checks := []func(entry *Entry) error{
+			func(entry *Entry) error { return validateStatus(entry.Status) },
+			validateQuery,
+			validatePathMode,
+			validateRedirect,
 		}
-
-		uri, err := url.ParseRequestURI(entry.Redirect)
-		if err != nil {
-			log.Debug().Msg("Redirect uri is not fully qualified.")
-			return err
-		}
-
-		if uri.Scheme != "https" {
-			msg := fmt.Sprintf("Redirect uri scheme on [%s] needs to be changed and use 'https' as the scheme.", uri.String())
-			return errors.New(msg)
+		for _, check := range checks {
+			if err := check(entry); err != nil {
+				errs = append(errs, err)
+			}
 		}
 
-		return nil
+		return errs
 	}
 
-	for path := range *m {
+	var problems []Problem
+	for _, path := range sortedKeys(m) {
 		entry := m.Get(path)
 		logEntry(&entry, path)
-		if err := validate(&entry, path); err != nil {
-			return err
+		for _, err := range validate(&entry, path) {
+			problems = append(problems, problemOf(host, path, err))
 		}
-
 	}
 
-	return nil
+	return problems
 }
 
 // MappingsFile describes the mapping file
 type MappingsFile struct {
-	Mappings map[string]*Mapping `yaml:"mapping,omitempty"`
+	Defaults Defaults                `yaml:"defaults,omitempty"`
+	Hosts    map[string]*HostOptions `yaml:"hosts,omitempty"`
+	Mappings map[string]*Mapping     `yaml:"mapping,omitempty"`
+	Tests    []Expectation           `yaml:"tests,omitempty"` // requests the mappings must answer a certain way
+	routes   map[string]*routes      // compiled lookups per host, see `compile()`
+	hosts    *hostIndex              // compiled host lookup, see `compile()`
+	source   positions               // where sections, hosts and paths are in the yaml, when parsed
+	checksum string                  // sha256 of the yaml, when parsed
 }
 
 // NewMappingsFile is a factory which creates new mappings file.
@@ -109,78 +159,101 @@ func NewMappingsFile() *MappingsFile {
 	}
 }
 
-// Validate validates the mappings file entirely
+// Validate validates the mappings file entirely, the error lists every problem found, see ValidationError
 func (m *MappingsFile) Validate() error {
 	if len(m.Mappings) == 0 {
-		return errors.New("Mapping file is empty or has no entries, please provide some")
+		return validationError([]Problem{{Rule: RuleEmpty, Message: "Mapping file is empty or has no entries, please provide some"}})
+	}
+
+	hosts := make([]string, 0, len(m.Mappings))
+	for host := range m.Mappings {
+		hosts = append(hosts, host)
 	}
-	for host, entry := range m.Mappings {
+	sort.Strings(hosts)
+
+	var problems []Problem
+	for _, host := range hosts {
 		if host == "localhost" {
-			return errors.New("Localhost is reserved, you cannot use this host")
+			problems = append(problems, Problem{Host: host, Rule: RuleReservedHost, Message: "Localhost is reserved, you cannot use this host"})
+			continue
 		}
-		if err := entry.Validate(); err != nil {
-			return err
+		if entry := m.Mappings[host]; entry != nil {
+			problems = append(problems, entry.problems(host)...)
 		}
 	}
-
-	return nil
+	problems = append(problems, m.hostProblems()...)
+	m.source.locate("mapping", problems)
+
+	hostOptions := m.hostOptionProblems()
+	m.source.locate("hosts", hostOptions)
+	defaults := m.defaultProblems()
+	m.source.locate("defaults", defaults)
+	tests := m.expectationProblems()
+	m.source.locate("tests", tests)
+
+	problems = append(append(problems, hostOptions...), defaults...)
+	return validationError(append(problems, tests...))
 }
 
-// GetRedirectURI gets the URI of a matching host and path from the mappings file
+// GetRedirectURI gets the redirect of the entry matching a host and path, see Match, empty when none matches
 func (m *MappingsFile) GetRedirectURI(host string, path string) string {
-	if mappingEntry, ok := m.Mappings[host]; ok {
-		// look for specific
-		if entry := mappingEntry.Get(path); entry.Redirect != "" {
-			return entry.Redirect
-		}
-
-		// look for root TODO: might be better to sort later
-		if entry := mappingEntry.Get("/"); entry.Redirect != "" {
-			return entry.Redirect
-		}
+	match, err := m.Match(host, path)
+	if err != nil {
+		return ""
 	}
 
-	msg := fmt.Sprintf("Could not find host and path [%s%s]", host, path)
-	log.Debug().Msg(msg)
-	return ""
+	return match.Redirect
 }
 
 // GetMappingEntry returns an entry for a particular mapping given the user defined host and path
 func (m *MappingsFile) GetMappingEntry(host string, path string) (*Entry, error) {
-	if mappingEntry, ok := m.Mappings[host]; ok {
-		// look for specific
-		if entry := mappingEntry.Get(path); entry.Redirect != "" {
-			return &entry, nil
-		}
+	match, err := m.Match(host, path)
+	if err != nil {
+		return nil, err
+	}
 
-		// look for root TODO: might be better to sort later
-		if entry := mappingEntry.Get("/"); entry.Redirect != "" {
-			return &entry, nil
-		}
+	return match.Entry, nil
+}
+
+// Checksum returns the hex encoded sha256 of the yaml the file was parsed from, empty when it was not parsed
+func (m *MappingsFile) Checksum() string {
+	return m.checksum
+}
 
-		// look for wildcard
-		if entry := mappingEntry.Get("*"); entry.Redirect != "" {
-			return &entry, nil
+// EntryCount returns the number of path entries across all hosts
+func (m *MappingsFile) EntryCount() int {
+	count := 0
+	for _, mapping := range m.Mappings {
+		if mapping != nil {
+			count += len(*mapping)
 		}
 	}
 
-	msg := fmt.Sprintf("Could not find host and path [%s%s]", host, path)
-	log.Debug().Msg(msg)
-	return nil, errors.New(msg)
+	return count
 }
 
-// Parse the mapping file.
+// Parse the mapping file. Errors decoding the yaml or validating the mappings are a ValidationError.
 func Parse(data []byte) (*MappingsFile, error) {
 	mappingFile := NewMappingsFile()
+	mappingFile.checksum = fmt.Sprintf("%x", sha256.Sum256(data))
 
-	if err := yaml.Unmarshal([]byte(data), mappingFile); err != nil {
-		return mappingFile, err
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &root); err != nil {
+		return mappingFile, validationError(yamlProblems(err))
+	}
+	if root.Kind == 0 { // an empty document decodes to nothing at all
+		return mappingFile, mappingFile.Validate()
+	}
+	if err := root.Decode(mappingFile); err != nil {
+		return mappingFile, validationError(yamlProblems(err))
 	}
+	mappingFile.source = recordPositions(&root)
 
 	if err := mappingFile.Validate(); err != nil {
 		return mappingFile, err
 	}
 
+	mappingFile.compile()
 	return mappingFile, nil
 }
 
@@ -1,15 +1,17 @@
 package mapping
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 func newEntry(immediate bool, redirect string) Entry {
 	return Entry{
-		immediate,
-		redirect,
+		Immediate: immediate,
+		Redirect:  redirect,
 	}
 }
 
@@ -252,6 +254,47 @@ mapping:
 	}
 }
 
+func Test_GetRedirectURIMatches(t *testing.T) {
+	testFile := `---
+defaults:
+  host: www.example.org
+mapping:
+  www.example.org:
+    "/":
+      redirect: https://new.example.org
+  "*.legacy.example.org":
+    "/docs/*":
+      redirect: https://new.example.org/docs
+    "/article/(\\d+)":
+      regex: true
+      redirect: https://new.example.org/a/$1
+`
+	data, err := Parse([]byte(testFile))
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	testData := map[string][2]string{
+		"prefix":       {"a.legacy.example.org", "/docs/guide"},
+		"regex":        {"a.legacy.example.org", "/article/42"},
+		"default host": {"unknown.example.org", "/anything"},
+	}
+	expected := map[string]string{
+		"prefix":       "https://new.example.org/docs",
+		"regex":        "https://new.example.org/a/42",
+		"default host": "https://new.example.org",
+	}
+	for name, request := range testData {
+		if uri := data.GetRedirectURI(request[0], request[1]); uri != expected[name] {
+			t.Errorf("Expected [%s] for the %s entry, got [%s]", expected[name], name, uri)
+		}
+	}
+
+	if uri := data.GetRedirectURI("a.legacy.example.org", "/other"); uri != "" {
+		t.Errorf("Expected no redirect when no entry matches, got [%s]", uri)
+	}
+}
+
 func Test_MappingFileWithEmptyPath(t *testing.T) {
 	redirectMap := MappingsFile{
 		Mappings: map[string]*Mapping{
@@ -393,3 +436,78 @@ mapping:
 		t.Errorf("Expected to see root as that is the fall through wildcard path when looking for path: [%s], error: [%s]", path, entryError)
 	}
 }
+
+func Test_MatchReturnsKeys(t *testing.T) {
+	host := "testhost"
+	testFile := fmt.Sprintf(`---
+mapping:
+  %s:
+    "/my-path":
+      redirect: https://localhost:8081
+    "/":
+      redirect: https://localhost:8082
+`, host)
+
+	mappingsFile, err := Parse([]byte(testFile))
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	if match, err := mappingsFile.Match(host, "/my-path"); err != nil {
+		t.Errorf("Expected to match path [/my-path], error: [%s]", err)
+	} else if match.Host != host || match.Path != "/my-path" {
+		t.Errorf("Expected match on [%s/my-path], got [%s%s]", host, match.Host, match.Path)
+	}
+
+	if match, err := mappingsFile.Match(host, "/elsewhere"); err != nil {
+		t.Errorf("Expected to match root, error: [%s]", err)
+	} else if match.Path != "/" {
+		t.Errorf("Expected match on root, got [%s]", match.Path)
+	}
+
+	if count := mappingsFile.EntryCount(); count != 2 {
+		t.Errorf("Expected [2] entries, got [%d]", count)
+	}
+}
+
+func Test_ValidateErrorContext(t *testing.T) {
+	testFile := `---
+mapping:
+  testhost:
+    "/my-path":
+      redirect: http://localhost:8081
+`
+	_, err := Parse([]byte(testFile))
+	if err == nil {
+		t.Fatalf("Expected an http redirect to be invalid")
+	}
+
+	for _, expected := range []string{"host [testhost]", "path [/my-path]"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected to find [%s] in the error, got [%s]", expected, err)
+		}
+	}
+}
+
+func Test_Checksum(t *testing.T) {
+	data := []byte(`---
+mapping:
+  a.example.org:
+    "/":
+      redirect: https://b.example.org
+`)
+	mappingsFile, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Data was expected to be valid: %v", err)
+	}
+
+	// sha256 of the data above
+	expected := fmt.Sprintf("%x", sha256.Sum256(data))
+	if mappingsFile.Checksum() != expected || len(expected) != 64 {
+		t.Errorf("Expected checksum [%s], got [%s]", expected, mappingsFile.Checksum())
+	}
+
+	if NewMappingsFile().Checksum() != "" {
+		t.Errorf("Expected no checksum for a file which was not parsed")
+	}
+}
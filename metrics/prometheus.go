@@ -0,0 +1,254 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ContentType is the content type of the Prometheus text exposition format
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	// OutcomeFriendly is the outcome of a request answered with the friendly redirect page
+	OutcomeFriendly = "friendly"
+	// OutcomeImmediate is the outcome of a request answered with an immediate redirect
+	OutcomeImmediate = "immediate"
+	// OutcomeNotFound is the outcome of a request which matched no mapping entry
+	OutcomeNotFound = "not_found"
+	// OutcomeUpgrade is the outcome of a plain http request redirected to https
+	OutcomeUpgrade = "upgrade"
+
+	// LoadStartup is the reason used when the mapping file is loaded as the server starts
+	LoadStartup = "startup"
+	// LoadReload is the reason used when the mapping file is reloaded while the server runs
+	LoadReload = "reload"
+)
+
+// DefaultBuckets are the upper bounds (in seconds) of the request latency histogram. Redirects are
+// answered well under a millisecond, so the buckets are finer than the usual Prometheus defaults.
+var DefaultBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+type requestKey struct {
+	host    string
+	path    string
+	outcome string
+}
+
+type loadKey struct {
+	reason string
+	result string
+}
+
+// EntryHits are the hits of a single mapping entry, see Exporter.SetEntryHits
+type EntryHits struct {
+	Host    string
+	Path    string
+	Count   uint64
+	LastHit time.Time // zero when never hit
+}
+
+type histogram struct {
+	counts []uint64 // one per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(buckets []float64, value float64) {
+	for i, bound := range buckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Exporter collects the redirector metrics and renders them in the Prometheus text exposition format.
+type Exporter struct {
+	mu        sync.Mutex
+	buckets   []float64
+	requests  map[requestKey]uint64
+	durations map[string]*histogram
+	loads     map[loadKey]uint64
+	hosts     int
+	entries   int
+	hits      []EntryHits
+}
+
+// NewExporter is a factory which creates a new, empty Exporter.
+func NewExporter() *Exporter {
+	return &Exporter{
+		buckets:   DefaultBuckets,
+		requests:  map[requestKey]uint64{},
+		durations: map[string]*histogram{},
+		loads:     map[loadKey]uint64{},
+	}
+}
+
+// ObserveRequest records a single request, the mapping host and path it matched and how it was answered.
+func (e *Exporter) ObserveRequest(host string, path string, outcome string, duration time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests[requestKey{host, path, outcome}]++
+
+	h, ok := e.durations[outcome]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(e.buckets))}
+		e.durations[outcome] = h
+	}
+	h.observe(e.buckets, duration.Seconds())
+}
+
+// ObserveMappingLoad records an attempt to load the mapping file.
+func (e *Exporter) ObserveMappingLoad(reason string, success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.loads[loadKey{reason, result}]++
+}
+
+// SetMappings records the number of hosts and path entries of the mapping file in use.
+func (e *Exporter) SetMappings(hosts int, entries int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.hosts = hosts
+	e.entries = entries
+}
+
+// SetEntryHits records the hits of every mapping entry in use, counted across restarts when they are persisted.
+func (e *Exporter) SetEntryHits(hits []EntryHits) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.hits = hits
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// WriteTo renders every metric in the Prometheus text exposition format.
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	out := &countingWriter{w: bufio.NewWriter(w)}
+
+	out.printf("# HELP redirector_requests_total Requests served, by mapping host, mapping path and outcome.\n")
+	out.printf("# TYPE redirector_requests_total counter\n")
+	requestKeys := make([]requestKey, 0, len(e.requests))
+	for key := range e.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		return a.outcome < b.outcome
+	})
+	for _, key := range requestKeys {
+		out.printf("redirector_requests_total{host=\"%s\",path=\"%s\",outcome=\"%s\"} %d\n",
+			escapeLabel(key.host), escapeLabel(key.path), key.outcome, e.requests[key])
+	}
+
+	out.printf("# HELP redirector_request_duration_seconds Time taken to answer a request, by outcome.\n")
+	out.printf("# TYPE redirector_request_duration_seconds histogram\n")
+	outcomes := make([]string, 0, len(e.durations))
+	for outcome := range e.durations {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Strings(outcomes)
+	for _, outcome := range outcomes {
+		h := e.durations[outcome]
+		var cumulative uint64
+		for i, bound := range e.buckets {
+			cumulative += h.counts[i]
+			out.printf("redirector_request_duration_seconds_bucket{outcome=\"%s\",le=\"%s\"} %d\n", outcome, formatFloat(bound), cumulative)
+		}
+		out.printf("redirector_request_duration_seconds_bucket{outcome=\"%s\",le=\"+Inf\"} %d\n", outcome, h.count)
+		out.printf("redirector_request_duration_seconds_sum{outcome=\"%s\"} %s\n", outcome, formatFloat(h.sum))
+		out.printf("redirector_request_duration_seconds_count{outcome=\"%s\"} %d\n", outcome, h.count)
+	}
+
+	out.printf("# HELP redirector_mapping_loads_total Attempts to load the mapping file, by reason and result.\n")
+	out.printf("# TYPE redirector_mapping_loads_total counter\n")
+	loadKeys := make([]loadKey, 0, len(e.loads))
+	for key := range e.loads {
+		loadKeys = append(loadKeys, key)
+	}
+	sort.Slice(loadKeys, func(i, j int) bool {
+		if loadKeys[i].reason != loadKeys[j].reason {
+			return loadKeys[i].reason < loadKeys[j].reason
+		}
+		return loadKeys[i].result < loadKeys[j].result
+	})
+	for _, key := range loadKeys {
+		out.printf("redirector_mapping_loads_total{reason=\"%s\",result=\"%s\"} %d\n", key.reason, key.result, e.loads[key])
+	}
+
+	out.printf("# HELP redirector_mapping_hosts Hosts in the mapping file in use.\n")
+	out.printf("# TYPE redirector_mapping_hosts gauge\n")
+	out.printf("redirector_mapping_hosts %d\n", e.hosts)
+	out.printf("# HELP redirector_mapping_entries Path entries across all hosts in the mapping file in use.\n")
+	out.printf("# TYPE redirector_mapping_entries gauge\n")
+	out.printf("redirector_mapping_entries %d\n", e.entries)
+
+	out.printf("# HELP redirector_entry_hits Requests answered by each mapping entry, including counts persisted across restarts.\n")
+	out.printf("# TYPE redirector_entry_hits gauge\n")
+	for _, hit := range e.hits {
+		out.printf("redirector_entry_hits{host=\"%s\",path=\"%s\"} %d\n", escapeLabel(hit.Host), escapeLabel(hit.Path), hit.Count)
+	}
+	out.printf("# HELP redirector_entry_last_hit_timestamp_seconds Unix time of the last request answered by each mapping entry, 0 when never hit.\n")
+	out.printf("# TYPE redirector_entry_last_hit_timestamp_seconds gauge\n")
+	for _, hit := range e.hits {
+		lastHit := int64(0)
+		if !hit.LastHit.IsZero() {
+			lastHit = hit.LastHit.Unix()
+		}
+		out.printf("redirector_entry_last_hit_timestamp_seconds{host=\"%s\",path=\"%s\"} %d\n", escapeLabel(hit.Host), escapeLabel(hit.Path), lastHit)
+	}
+
+	return out.flush()
+}
+
+// countingWriter keeps the first error and the number of bytes written, so WriteTo can stay linear.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) printf(format string, args ...interface{}) {
+	if c.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(c.w, format, args...)
+	c.n += int64(n)
+	c.err = err
+}
+
+func (c *countingWriter) flush() (int64, error) {
+	if c.err != nil {
+		return c.n, c.err
+	}
+	return c.n, c.w.Flush()
+}
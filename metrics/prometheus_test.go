@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ExporterEmpty(t *testing.T) {
+	exporter := NewExporter()
+	var out bytes.Buffer
+
+	if _, err := exporter.WriteTo(&out); err != nil {
+		t.Errorf("Did not expect an error writing metrics: %v", err)
+	}
+
+	for _, expected := range []string{
+		"# TYPE redirector_requests_total counter",
+		"# TYPE redirector_request_duration_seconds histogram",
+		"redirector_mapping_hosts 0",
+		"redirector_mapping_entries 0",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected to find [%s] in the exposition, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func Test_ExporterObservations(t *testing.T) {
+	exporter := NewExporter()
+	exporter.ObserveRequest("testhost", "/my-path", OutcomeFriendly, 200*time.Microsecond)
+	exporter.ObserveRequest("testhost", "/my-path", OutcomeFriendly, 2*time.Second)
+	exporter.ObserveRequest("", "", OutcomeNotFound, time.Microsecond)
+	exporter.ObserveMappingLoad(LoadStartup, true)
+	exporter.ObserveMappingLoad(LoadReload, false)
+	exporter.SetMappings(2, 5)
+	exporter.SetEntryHits([]EntryHits{
+		{Host: "testhost", Path: "/my-path", Count: 12, LastHit: time.Unix(1614592800, 0)},
+		{Host: "testhost", Path: "/dead", Count: 0},
+	})
+
+	var out bytes.Buffer
+	n, err := exporter.WriteTo(&out)
+	if err != nil {
+		t.Errorf("Did not expect an error writing metrics: %v", err)
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("Expected WriteTo to report [%d] bytes written, got [%d]", out.Len(), n)
+	}
+
+	for _, expected := range []string{
+		`redirector_requests_total{host="testhost",path="/my-path",outcome="friendly"} 2`,
+		`redirector_requests_total{host="",path="",outcome="not_found"} 1`,
+		`redirector_request_duration_seconds_bucket{outcome="friendly",le="0.00025"} 1`,
+		`redirector_request_duration_seconds_bucket{outcome="friendly",le="0.1"} 1`,
+		`redirector_request_duration_seconds_bucket{outcome="friendly",le="+Inf"} 2`,
+		`redirector_request_duration_seconds_count{outcome="friendly"} 2`,
+		`redirector_mapping_loads_total{reason="startup",result="success"} 1`,
+		`redirector_mapping_loads_total{reason="reload",result="failure"} 1`,
+		"redirector_mapping_hosts 2",
+		"redirector_mapping_entries 5",
+		`redirector_entry_hits{host="testhost",path="/my-path"} 12`,
+		`redirector_entry_hits{host="testhost",path="/dead"} 0`,
+		`redirector_entry_last_hit_timestamp_seconds{host="testhost",path="/my-path"} 1614592800`,
+		`redirector_entry_last_hit_timestamp_seconds{host="testhost",path="/dead"} 0`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected to find [%s] in the exposition, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func Test_EscapeLabel(t *testing.T) {
+	if actual := escapeLabel("a\"b\\c\nd"); actual != `a\"b\\c\nd` {
+		t.Errorf("Expected label to be escaped, got [%s]", actual)
+	}
+}
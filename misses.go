@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"go-redirector/errors"
+	"go-redirector/misses"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/urfave/cli"
+)
+
+// MissesRoute is the admin route reporting the most missed hosts and paths
+const MissesRoute = "/admin/misses"
+
+// DefaultMissesServer is the default server the misses command asks, one run with the defaults serving tls on loopback
+var DefaultMissesServer = fmt.Sprintf("https://127.0.0.1:%d", DefaultPortTLS)
+
+func (c *Config) setMissCapacity(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	c.MissCapacity = capacity
+}
+
+// observeMiss records a request which matched no mapping entry with the misses tracker
+func (f *FastServer) observeMiss(host string, path string) {
+	f.Misses.Observe(strings.ToLower(host), path)
+}
+
+/*
+*
+Respond with the most missed hosts and paths as json, only to localhost over loopback, see localOnly.
+The `limit` query parameter caps the number of misses reported.
+*/
+func (f *FastServer) missesReport(c *fiber.Ctx) error {
+	limit, err := strconv.Atoi(c.Query("limit", "0"))
+	if err != nil || limit < 0 {
+		return c.Status(400).SendString("limit must be a positive number")
+	}
+
+	return c.Status(200).JSON(f.Misses.Top(limit))
+}
+
+/*
+*
+Fetch the misses report of a running server. The request is made with the host `localhost`
+whatever the address, the report is only answered over loopback so it runs on the server's machine.
+*/
+func fetchMisses(server string, limit int, insecure bool) (*misses.Report, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(server, "/") + MissesRoute)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse server [%s]: %v", server, err)
+	}
+	if limit > 0 {
+		endpoint.RawQuery = url.Values{"limit": {strconv.Itoa(limit)}}.Encode()
+	}
+
+	request, err := http.NewRequest("GET", endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Host = "localhost"
+
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}}, //nolint:gosec
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("server [%s] answered [%d]", server, response.StatusCode)
+	}
+
+	var report misses.Report
+	if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("could not read the report of [%s]: %v", server, err)
+	}
+
+	return &report, nil
+}
+
+// writeMisses writes a report as a table, most missed first
+func writeMisses(report *misses.Report, out io.Writer) error {
+	fmt.Fprintf(out, "%d miss(es) since %s, tracking the top %d\n\n", report.Total, report.Since.Format(time.RFC3339), report.Capacity)
+
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "COUNT\tHOST\tPATH\tLAST SEEN")
+	for _, miss := range report.Misses {
+		count := strconv.FormatUint(miss.Count, 10)
+		if miss.Error > 0 {
+			count = fmt.Sprintf("~%d", miss.Count)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", count, miss.Host, miss.Path, miss.LastSeen.Format(time.RFC3339))
+	}
+
+	return table.Flush()
+}
+
+func missesAction(c *cli.Context) error {
+	report, err := fetchMisses(c.String("server"), c.Int("limit"), c.Bool("insecure"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Could not fetch misses: %v", err), errors.ExitCodeExecutionFailure)
+	}
+
+	if c.Bool("json") {
+		err = json.NewEncoder(c.App.Writer).Encode(report)
+	} else {
+		err = writeMisses(report, c.App.Writer)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), errors.ExitCodeExecutionFailure)
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package misses
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxPathLength is the longest path tracked, longer paths are truncated so clients cannot grow the tracker
+const MaxPathLength = 512
+
+// Miss is a host and path which matched no mapping entry
+type Miss struct {
+	Host     string    `json:"host"`
+	Path     string    `json:"path"`
+	Count    uint64    `json:"count"` // requests counted, may overestimate by up to Error
+	Error    uint64    `json:"error"` // count inherited from the miss it evicted, 0 when the count is exact
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Report is a snapshot of the most missed hosts and paths, most missed first
+type Report struct {
+	Since    time.Time `json:"since"`    // when tracking started
+	Total    uint64    `json:"total"`    // every miss observed, tracked or not
+	Capacity int       `json:"capacity"` // most misses tracked at once
+	Misses   []Miss    `json:"misses"`
+}
+
+type key struct {
+	host string
+	path string
+}
+
+type counter struct {
+	Miss
+	index int // position in the heap
+}
+
+// minHeap orders counters by count, so the least missed is evicted first
+type minHeap []*counter
+
+func (h minHeap) Len() int           { return len(h) }
+func (h minHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h minHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *minHeap) Push(x interface{}) {
+	c := x.(*counter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *minHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+/*
+*
+Tracker counts requests which matched no mapping entry in bounded memory, using the Space-Saving
+algorithm. Once `capacity` misses are tracked a new one evicts the least missed and inherits its
+count, so a miss seen more than Total/capacity times is always reported. A nil Tracker tracks nothing.
+*/
+type Tracker struct {
+	mu       sync.Mutex
+	capacity int
+	counters map[key]*counter
+	heap     minHeap
+	total    uint64
+	since    time.Time
+}
+
+// NewTracker is a factory which creates a Tracker of the `capacity` most missed paths. Returns nil when capacity is 0.
+func NewTracker(capacity int) *Tracker {
+	if capacity <= 0 {
+		return nil
+	}
+
+	return &Tracker{
+		capacity: capacity,
+		counters: make(map[key]*counter, capacity),
+		heap:     make(minHeap, 0, capacity),
+		since:    time.Now(),
+	}
+}
+
+// Observe counts a miss of `path` on `host`
+func (t *Tracker) Observe(host string, path string) {
+	if t == nil {
+		return
+	}
+	if len(path) > MaxPathLength {
+		path = path[:MaxPathLength]
+	}
+	now := time.Now()
+	k := key{host, path}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.total++
+	if c, ok := t.counters[k]; ok {
+		c.Count++
+		c.LastSeen = now
+		heap.Fix(&t.heap, c.index)
+		return
+	}
+
+	if len(t.heap) < t.capacity {
+		c := &counter{Miss: Miss{Host: host, Path: path, Count: 1, LastSeen: now}}
+		t.counters[k] = c
+		heap.Push(&t.heap, c)
+		return
+	}
+
+	// evict the least missed, the newcomer may have been among the misses it stood for
+	c := t.heap[0]
+	delete(t.counters, key{c.Host, c.Path})
+	c.Miss = Miss{Host: host, Path: path, Count: c.Count + 1, Error: c.Count, LastSeen: now}
+	t.counters[k] = c
+	heap.Fix(&t.heap, 0)
+}
+
+// Top returns the `limit` most missed hosts and paths, all of them when limit is 0 or less
+func (t *Tracker) Top(limit int) Report {
+	if t == nil {
+		return Report{Misses: []Miss{}}
+	}
+
+	t.mu.Lock()
+	report := Report{Since: t.since, Total: t.total, Capacity: t.capacity, Misses: make([]Miss, 0, len(t.heap))}
+	for _, c := range t.heap {
+		report.Misses = append(report.Misses, c.Miss)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(report.Misses, func(i, j int) bool {
+		a, b := report.Misses[i], report.Misses[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Path < b.Path
+	})
+	if limit > 0 && len(report.Misses) > limit {
+		report.Misses = report.Misses[:limit]
+	}
+
+	return report
+}
@@ -0,0 +1,109 @@
+package misses
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_NewTracker(t *testing.T) {
+	if tracker := NewTracker(0); tracker != nil {
+		t.Errorf("Expected no tracker with a capacity of 0")
+	}
+
+	// a nil tracker tracks nothing, without panicking
+	var tracker *Tracker
+	tracker.Observe("old.example.org", "/gone")
+	if report := tracker.Top(10); report.Total != 0 || len(report.Misses) != 0 {
+		t.Errorf("Expected an empty report, got %+v", report)
+	}
+}
+
+func Test_TrackerExact(t *testing.T) {
+	tracker := NewTracker(10)
+	for i := 0; i < 5; i++ {
+		tracker.Observe("old.example.org", "/blog")
+	}
+	for i := 0; i < 3; i++ {
+		tracker.Observe("old.example.org", "/about")
+	}
+	tracker.Observe("other.example.org", "/blog")
+
+	report := tracker.Top(0)
+	if report.Total != 9 || report.Capacity != 10 {
+		t.Errorf("Expected [9] misses with a capacity of [10], got [%d] and [%d]", report.Total, report.Capacity)
+	}
+
+	expected := []Miss{
+		{Host: "old.example.org", Path: "/blog", Count: 5},
+		{Host: "old.example.org", Path: "/about", Count: 3},
+		{Host: "other.example.org", Path: "/blog", Count: 1},
+	}
+	if len(report.Misses) != len(expected) {
+		t.Fatalf("Expected [%d] misses, got %+v", len(expected), report.Misses)
+	}
+	for i, miss := range report.Misses {
+		if miss.Host != expected[i].Host || miss.Path != expected[i].Path || miss.Count != expected[i].Count || miss.Error != 0 {
+			t.Errorf("Expected miss [%d] to be %+v, got %+v", i, expected[i], miss)
+		}
+		if miss.LastSeen.IsZero() {
+			t.Errorf("Expected a last seen time for %+v", miss)
+		}
+	}
+
+	if limited := tracker.Top(2); len(limited.Misses) != 2 || limited.Misses[0].Path != "/blog" {
+		t.Errorf("Expected the [2] most missed, got %+v", limited.Misses)
+	}
+}
+
+func Test_TrackerBounded(t *testing.T) {
+	tracker := NewTracker(5)
+
+	// a frequent miss among many one-off misses stays tracked
+	for i := 0; i < 1000; i++ {
+		tracker.Observe("old.example.org", fmt.Sprintf("/scan/%d", i))
+		if i%4 == 0 {
+			tracker.Observe("old.example.org", "/popular")
+		}
+	}
+
+	report := tracker.Top(0)
+	if len(report.Misses) != 5 {
+		t.Errorf("Expected [5] misses tracked, got [%d]", len(report.Misses))
+	}
+	if report.Total != 1250 {
+		t.Errorf("Expected [1250] misses observed, got [%d]", report.Total)
+	}
+	top := report.Misses[0]
+	if top.Path != "/popular" || top.Count < 250 || top.Count-top.Error > 250 {
+		t.Errorf("Expected [/popular] on top with at least [250] misses, got %+v", top)
+	}
+
+	tracker.Observe("old.example.org", "/"+strings.Repeat("a", 2*MaxPathLength))
+	for _, miss := range tracker.Top(0).Misses {
+		if len(miss.Path) > MaxPathLength {
+			t.Errorf("Expected paths to be truncated to [%d], got [%d]", MaxPathLength, len(miss.Path))
+		}
+	}
+}
+
+func Test_TrackerConcurrent(t *testing.T) {
+	tracker := NewTracker(3)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tracker.Observe("old.example.org", fmt.Sprintf("/%d", (i+j)%6))
+				tracker.Top(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if report := tracker.Top(0); report.Total != 800 || len(report.Misses) != 3 {
+		t.Errorf("Expected [800] misses and [3] tracked, got [%d] and [%d]", report.Total, len(report.Misses))
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-redirector/misses"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+*
+Serve the fiber app of the server on loopback, returning a get which requests a target with a host.
+The admin routes only answer over loopback. Connections wait in the backlog until the app serves,
+so unlike listenServer (see shutdown_test.go) no request is made up front.
+*/
+func serveLoopback(t *testing.T, fastServer *FastServer) func(host string, target string) *http.Response {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Test harness could not listen: %v", err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- fastServer.server.Listener(ln)
+	}()
+	t.Cleanup(func() {
+		_ = fastServer.server.Shutdown()
+		<-served
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse // answers are checked as sent, not followed
+		},
+	}
+	return func(host string, target string) *http.Response {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("http://%s%s", ln.Addr(), target), nil)
+		request.Host = host
+		resp, err := client.Do(request)
+		if err != nil {
+			t.Fatalf("Did not expect to get an error testing target [%s], error: %v", target, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+}
+
+func Test_SetMissCapacity(t *testing.T) {
+	config := NewConfig()
+	if config.MissCapacity != DefaultMissCapacity {
+		t.Errorf("Expected a default capacity of [%d], got [%d]", DefaultMissCapacity, config.MissCapacity)
+	}
+	if config.setMissCapacity(-1); config.MissCapacity != 0 {
+		t.Errorf("Expected a negative capacity to disable tracking, got [%d]", config.MissCapacity)
+	}
+	if NewFastServer(config, nil).Misses != nil {
+		t.Errorf("Expected no tracker with a capacity of 0")
+	}
+}
+
+func Test_MissesReport(t *testing.T) {
+	config := NewConfig()
+	config.setMappingFile("./tests/test-redirect-map.yml")
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+
+	get := serveLoopback(t, fastServer)
+
+	get("testhost", "/gone")
+	get("TESTHOST", "/gone?utm=1")
+	get("unknown.example.org", "/old")
+	get("testhost", "/favicon")
+	get("testhost", "/direct") // matched, not a miss
+
+	if resp := get("localhost", MissesRoute+"?limit=nope"); resp.StatusCode != 400 {
+		t.Errorf("Expected [400] for a bad limit, got [%d]", resp.StatusCode)
+	}
+
+	resp := get("localhost", MissesRoute+"?limit=2")
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected [200] for the report, got [%d]", resp.StatusCode)
+	}
+	var report misses.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("Expected a json report, error: %v", err)
+	}
+	if report.Total != 4 || report.Capacity != DefaultMissCapacity {
+		t.Errorf("Expected [4] misses with a capacity of [%d], got [%d] and [%d]", DefaultMissCapacity, report.Total, report.Capacity)
+	}
+	if len(report.Misses) != 2 {
+		t.Fatalf("Expected [2] misses with a limit, got %+v", report.Misses)
+	}
+	if top := report.Misses[0]; top.Host != "testhost" || top.Path != "/gone" || top.Count != 2 {
+		t.Errorf("Expected [testhost/gone] missed twice on top, got %+v", top)
+	}
+
+	// on another host the mappings apply, testhost has no entry for it
+	if resp := get("testhost", MissesRoute); resp.StatusCode != 404 {
+		t.Errorf("Expected [404] for the report on another host, got [%d]", resp.StatusCode)
+	}
+	if fastServer.Misses.Top(0).Total != 5 {
+		t.Errorf("Expected the report path on another host to be a miss")
+	}
+
+	// anyone can send `Host: localhost`, only loopback connections are answered
+	request := httptest.NewRequest("GET", MissesRoute, nil)
+	request.Host = "localhost"
+	if resp, err := fastServer.server.Test(request); err != nil || resp.StatusCode != 404 {
+		t.Errorf("Expected [404] for the report from a remote client, got [%v] with error: %v", resp, err)
+	}
+}
+
+func Test_FetchMisses(t *testing.T) {
+	tracker := misses.NewTracker(10)
+	tracker.Observe("old.example.org", "/blog")
+	tracker.Observe("old.example.org", "/blog")
+	tracker.Observe("old.example.org", "/about")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "localhost" || r.URL.Path != MissesRoute {
+			w.WriteHeader(404)
+			return
+		}
+		if r.URL.Query().Get("limit") != "5" {
+			t.Errorf("Expected the limit to be sent, got [%s]", r.URL.RawQuery)
+		}
+		_ = json.NewEncoder(w).Encode(tracker.Top(5))
+	}))
+	defer server.Close()
+
+	report, err := fetchMisses(server.URL+"/", 5, false)
+	if err != nil {
+		t.Fatalf("Did not expect an error fetching misses, error: %v", err)
+	}
+	if report.Total != 3 || len(report.Misses) != 2 {
+		t.Errorf("Expected [3] misses over [2] paths, got %+v", report)
+	}
+
+	var out bytes.Buffer
+	if err := writeMisses(report, &out); err != nil {
+		t.Fatalf("Did not expect an error writing misses, error: %v", err)
+	}
+	for _, expected := range []string{"3 miss(es) since", "COUNT  HOST", "2      old.example.org  /blog", "1      old.example.org  /about"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected to find [%s] in [%s]", expected, out.String())
+		}
+	}
+
+	// evicted counts are approximate
+	out.Reset()
+	approximate := &misses.Report{Misses: []misses.Miss{{Host: "h", Path: "/p", Count: 7, Error: 3, LastSeen: time.Now()}}}
+	_ = writeMisses(approximate, &out)
+	if !strings.Contains(out.String(), "~7") {
+		t.Errorf("Expected an approximate count, got [%s]", out.String())
+	}
+
+	if _, err := fetchMisses(server.URL+"/elsewhere", 0, false); err == nil {
+		t.Errorf("Expected an error when the server does not answer the report")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// HeaderXForwardedFor lists the client and every proxy in front of the last one
+	HeaderXForwardedFor = "X-Forwarded-For"
+	// HeaderXRealIP holds the client address alone
+	HeaderXRealIP = "X-Real-IP"
+	// HeaderForwarded is the standard header for proxies, see RFC 7239
+	HeaderForwarded = "Forwarded"
+	// HeaderXForwardedProto holds the scheme the client used, next to X-Forwarded-For and X-Real-IP
+	HeaderXForwardedProto = "X-Forwarded-Proto"
+	// HeaderXForwardedHost holds the host the client requested, next to X-Forwarded-For and X-Real-IP
+	HeaderXForwardedHost = "X-Forwarded-Host"
+
+	// DefaultProxyHeader is the default header read from trusted proxies
+	DefaultProxyHeader = HeaderXForwardedFor
+)
+
+// proxyHeaders are the headers which may be honoured, keyed by their lower case name
+var proxyHeaders = map[string]string{
+	strings.ToLower(HeaderXForwardedFor): HeaderXForwardedFor,
+	strings.ToLower(HeaderXRealIP):       HeaderXRealIP,
+	strings.ToLower(HeaderForwarded):     HeaderForwarded,
+}
+
+// parseProxyHeader returns the canonical name of a proxy header, the name is not case sensitive. Empty is the default.
+func parseProxyHeader(header string) (string, error) {
+	if strings.TrimSpace(header) == "" {
+		return DefaultProxyHeader, nil
+	}
+	if canonical, ok := proxyHeaders[strings.ToLower(strings.TrimSpace(header))]; ok {
+		return canonical, nil
+	}
+
+	return "", fmt.Errorf("proxy header [%s] is not one of [%s, %s, %s]", header, HeaderXForwardedFor, HeaderXRealIP, HeaderForwarded)
+}
+
+// parseTrustedProxies parses CIDRs, a bare address is trusted on its own
+func parseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	var trusted []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy [%s] is not an address or CIDR", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy [%s] is not an address or CIDR", value)
+		}
+		trusted = append(trusted, network)
+	}
+
+	return trusted, nil
+}
+
+func isTrusted(trusted []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedHop is what one proxy recorded about the request it received, oldest hop first
+type forwardedHop struct {
+	For   string
+	Proto string
+	Host  string
+}
+
+// parseNode returns the address of a node, without quotes, brackets or port. Obfuscated and unknown nodes are nil.
+func parseNode(node string) net.IP {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+
+	return net.ParseIP(strings.Trim(node, "[]"))
+}
+
+// parseForwarded reads the hops of Forwarded header values, see RFC 7239
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			var hop forwardedHop
+			for _, pair := range strings.Split(element, ";") {
+				parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				value := strings.Trim(strings.TrimSpace(parts[1]), `"`)
+				switch strings.ToLower(parts[0]) {
+				case "for":
+					hop.For = value
+				case "proto":
+					hop.Proto = strings.ToLower(value)
+				case "host":
+					hop.Host = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// headerValues returns every value of a header, whichever case it was sent in and however many times
+func headerValues(c *fiber.Ctx, name string) []string {
+	var values []string
+	c.Request().Header.VisitAll(func(key []byte, value []byte) {
+		if strings.EqualFold(string(key), name) {
+			values = append(values, string(value))
+		}
+	})
+
+	return values
+}
+
+// splitList splits comma separated header values into their trimmed, non empty items
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+
+	return items
+}
+
+// requestOrigin is where a request really came from, once trusted proxies are accounted for
+type requestOrigin struct {
+	IP     string // address of the client
+	Scheme string // scheme the client used
+	Host   string // host the client requested, mappings are matched on it
+}
+
+// applyHop takes the scheme and host a proxy recorded, when it recorded them
+func (o *requestOrigin) applyHop(hop forwardedHop) {
+	if hop.Proto == "http" || hop.Proto == "https" {
+		o.Scheme = hop.Proto
+	}
+	if hop.Host != "" {
+		o.Host = hop.Host
+	}
+}
+
+/*
+*
+Find where a request came from. Proxy headers are only read when the connection comes from a
+trusted proxy. The hops in the header are then walked back from the closest proxy, the client is
+the first hop which is not a trusted proxy, so clients cannot spoof their address by sending the
+header themselves. The host is the Host header unless a trusted proxy forwarded another.
+*/
+func (f *FastServer) origin(c *fiber.Ctx) requestOrigin {
+	remoteIP := c.Context().RemoteIP()
+	origin := requestOrigin{IP: remoteIP.String(), Scheme: "http", Host: c.Hostname()}
+	if c.Context().IsTLS() {
+		origin.Scheme = "https"
+	}
+
+	if !isTrusted(f.Config.TrustedProxies, remoteIP) {
+		return origin
+	}
+
+	var hops []forwardedHop
+	switch f.Config.ProxyHeader {
+	case HeaderForwarded:
+		hops = parseForwarded(headerValues(c, HeaderForwarded))
+	case HeaderXRealIP:
+		if values := splitList(headerValues(c, HeaderXRealIP)); len(values) > 0 {
+			hops = []forwardedHop{{For: values[len(values)-1]}}
+		}
+	default:
+		for _, address := range splitList(headerValues(c, HeaderXForwardedFor)) {
+			hops = append(hops, forwardedHop{For: address})
+		}
+	}
+	if f.Config.ProxyHeader != HeaderForwarded {
+		// the closest proxy sets or appends the scheme and host it saw, Forwarded records them per hop instead
+		closest := forwardedHop{}
+		if protos := splitList(headerValues(c, HeaderXForwardedProto)); len(protos) > 0 {
+			closest.Proto = strings.ToLower(protos[len(protos)-1])
+		}
+		if hosts := splitList(headerValues(c, HeaderXForwardedHost)); len(hosts) > 0 {
+			closest.Host = hosts[len(hosts)-1]
+		}
+		origin.applyHop(closest)
+	}
+
+	// walk back from the closest proxy, stopping at the first hop not trusted
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseNode(hops[i].For)
+		if ip == nil {
+			break // unknown or obfuscated, the proxy after it is as close to the client as we know
+		}
+		origin.IP = ip.String()
+		origin.applyHop(hops[i])
+		if !isTrusted(f.Config.TrustedProxies, ip) {
+			break
+		}
+	}
+
+	return origin
+}
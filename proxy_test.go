@@ -0,0 +1,224 @@
+package main
+
+import (
+	"go-redirector/errors"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func Test_ParseTrustedProxies(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", " 192.168.1.10 ", "", "2001:db8::/32", "::1"})
+	if err != nil {
+		t.Fatalf("Did not expect an error parsing valid proxies, error: %v", err)
+	}
+	if len(trusted) != 4 {
+		t.Errorf("Expected [4] trusted networks, got [%d]", len(trusted))
+	}
+
+	testData := []struct {
+		ip      string
+		trusted bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.10", true},
+		{"192.168.1.11", false},
+		{"2001:db8::7", true},
+		{"::1", true},
+		{"8.8.8.8", false},
+	}
+	for _, test := range testData {
+		if actual := isTrusted(trusted, net.ParseIP(test.ip)); actual != test.trusted {
+			t.Errorf("Expected [%s] trusted to be [%v], got [%v]", test.ip, test.trusted, actual)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "proxy.example.org"} {
+		if _, err := parseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("Expected an error parsing [%s]", bad)
+		}
+	}
+}
+
+func Test_ParseProxyHeader(t *testing.T) {
+	testData := map[string]string{
+		"x-forwarded-for": HeaderXForwardedFor,
+		"X-Real-IP":       HeaderXRealIP,
+		"forwarded":       HeaderForwarded,
+		"":                DefaultProxyHeader,
+	}
+	for header, expected := range testData {
+		if actual, err := parseProxyHeader(header); err != nil || actual != expected {
+			t.Errorf("Expected [%s] for [%s], got [%s] with error: %v", expected, header, actual, err)
+		}
+	}
+
+	if _, err := parseProxyHeader("X-Client-IP"); err == nil {
+		t.Errorf("Expected an error for an unsupported header")
+	}
+}
+
+func Test_ParseForwarded(t *testing.T) {
+	hops := parseForwarded([]string{
+		`for=192.0.2.60;proto=HTTPS;host=old.example.org, for="[2001:db8:cafe::17]:4711"`,
+		`for=unknown;by=10.0.0.1`,
+	})
+
+	expected := []forwardedHop{
+		{For: "192.0.2.60", Proto: "https", Host: "old.example.org"},
+		{For: "[2001:db8:cafe::17]:4711"},
+		{For: "unknown"},
+	}
+	if len(hops) != len(expected) {
+		t.Fatalf("Expected [%d] hops, got %v", len(expected), hops)
+	}
+	for i := range expected {
+		if hops[i] != expected[i] {
+			t.Errorf("Expected hop [%+v], got [%+v]", expected[i], hops[i])
+		}
+	}
+
+	nodes := map[string]string{
+		"192.0.2.60":                 "192.0.2.60",
+		"192.0.2.60:80":              "192.0.2.60",
+		`"[2001:db8:cafe::17]:4711"`: "2001:db8:cafe::17",
+		"[2001:db8:cafe::17]":        "2001:db8:cafe::17",
+		"2001:db8:cafe::17":          "2001:db8:cafe::17",
+	}
+	for node, expected := range nodes {
+		if ip := parseNode(node); ip == nil || ip.String() != expected {
+			t.Errorf("Expected [%s] for node [%s], got [%v]", expected, node, ip)
+		}
+	}
+	for _, node := range []string{"unknown", "_hidden", ""} {
+		if ip := parseNode(node); ip != nil {
+			t.Errorf("Expected no address for node [%s], got [%v]", node, ip)
+		}
+	}
+}
+
+func Test_Origin(t *testing.T) {
+	// requests made through fiber's Test come from 0.0.0.0, for example.com
+	testData := []struct {
+		trusted  []string
+		header   string
+		headers  map[string]string
+		expected requestOrigin
+	}{
+		// proxy headers from untrusted connections are ignored
+		{nil, HeaderXForwardedFor, map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https"}, requestOrigin{"0.0.0.0", "http", "example.com"}},
+		{[]string{"0.0.0.0"}, HeaderXForwardedFor, map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https"}, requestOrigin{"203.0.113.9", "https", "example.com"}},
+		// a client spoofing the header is stopped at the first untrusted hop
+		{[]string{"0.0.0.0", "10.0.0.0/8"}, HeaderXForwardedFor, map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.9, 10.0.0.2"}, requestOrigin{"203.0.113.9", "http", "example.com"}},
+		// every hop trusted, the first one is the client
+		{[]string{"0.0.0.0", "10.0.0.0/8"}, HeaderXForwardedFor, map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, requestOrigin{"10.0.0.3", "http", "example.com"}},
+		// the wrong header is not read
+		{[]string{"0.0.0.0"}, HeaderXRealIP, map[string]string{"X-Forwarded-For": "203.0.113.9"}, requestOrigin{"0.0.0.0", "http", "example.com"}},
+		{[]string{"0.0.0.0"}, HeaderXRealIP, map[string]string{"X-Real-IP": "203.0.113.9"}, requestOrigin{"203.0.113.9", "http", "example.com"}},
+		{[]string{"0.0.0.0"}, HeaderForwarded, map[string]string{"Forwarded": `for=203.0.113.9;proto=https, for=10.0.0.2`}, requestOrigin{"10.0.0.2", "http", "example.com"}},
+		{[]string{"0.0.0.0", "10.0.0.0/8"}, HeaderForwarded, map[string]string{"Forwarded": `for=203.0.113.9;proto=https, for=10.0.0.2`}, requestOrigin{"203.0.113.9", "https", "example.com"}},
+		{[]string{"0.0.0.0", "10.0.0.0/8"}, HeaderForwarded, map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https`}, requestOrigin{"2001:db8::1", "https", "example.com"}},
+		// obfuscated hops stop the walk at the proxy which saw them
+		{[]string{"0.0.0.0", "10.0.0.0/8"}, HeaderForwarded, map[string]string{"Forwarded": `for=_hidden, for=10.0.0.2`}, requestOrigin{"10.0.0.2", "http", "example.com"}},
+		// forwarded hosts, only from trusted proxies
+		{nil, HeaderXForwardedFor, map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Host": "old.example.org"}, requestOrigin{"0.0.0.0", "http", "example.com"}},
+		{[]string{"0.0.0.0"}, HeaderXForwardedFor, map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Host": "old.example.org"}, requestOrigin{"203.0.113.9", "http", "old.example.org"}},
+		{[]string{"0.0.0.0"}, HeaderXRealIP, map[string]string{"X-Real-IP": "203.0.113.9", "X-Forwarded-Host": "old.example.org:8443"}, requestOrigin{"203.0.113.9", "http", "old.example.org:8443"}},
+		{[]string{"0.0.0.0", "10.0.0.0/8"}, HeaderForwarded, map[string]string{"Forwarded": `for=203.0.113.9;host=old.example.org, for=10.0.0.2;host=ingress.internal`}, requestOrigin{"203.0.113.9", "http", "old.example.org"}},
+		// an untrusted hop's host is taken from the proxy which saw it
+		{[]string{"0.0.0.0"}, HeaderForwarded, map[string]string{"Forwarded": `for=203.0.113.9;host=spoofed.example.org, for=10.0.0.2;host=old.example.org`}, requestOrigin{"10.0.0.2", "http", "old.example.org"}},
+	}
+
+	for _, test := range testData {
+		config := NewConfig()
+		config.setProxies(test.trusted, test.header)
+		fastServer := NewFastServer(config, nil)
+
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error {
+			origin := fastServer.origin(c)
+			return c.SendString(origin.IP + " " + origin.Scheme + " " + origin.Host)
+		})
+
+		request := httptest.NewRequest("GET", "/", nil)
+		for name, value := range test.headers {
+			request.Header.Set(name, value)
+		}
+		resp, err := app.Test(request)
+		if err != nil {
+			t.Fatalf("Did not expect to get an error, error: %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if expected := test.expected.IP + " " + test.expected.Scheme + " " + test.expected.Host; string(body) != expected {
+			t.Errorf("Expected origin [%s] trusting %v with %v, got [%s]", expected, test.trusted, test.headers, body)
+		}
+	}
+}
+
+func Test_ConfigProxies(t *testing.T) {
+	config := NewConfig()
+	config.exitFunc = func(code int) {
+		t.Errorf("Did not expect to see the app exit on valid proxies")
+	}
+	config.setProxies([]string{"10.0.0.0/8"}, "forwarded")
+	if len(config.TrustedProxies) != 1 || config.ProxyHeader != HeaderForwarded {
+		t.Errorf("Expected [1] trusted network and [%s], got [%d] and [%s]", HeaderForwarded, len(config.TrustedProxies), config.ProxyHeader)
+	}
+
+	for _, test := range []struct {
+		trusted []string
+		header  string
+	}{
+		{[]string{"nope"}, HeaderXForwardedFor},
+		{[]string{"10.0.0.0/8"}, "X-Client-IP"},
+	} {
+		exitReached := false
+		config.exitFunc = func(code int) {
+			if code != errors.ExitCodeBadProxyConfig {
+				t.Errorf("Expected exit code of [%v], got [%v]", errors.ExitCodeBadProxyConfig, code)
+			}
+			exitReached = true
+		}
+		config.setProxies(test.trusted, test.header)
+		if !exitReached {
+			t.Errorf("Expected the app to exit for proxies %v with header [%s]", test.trusted, test.header)
+		}
+	}
+}
+
+func Test_FastServerForwardedHost(t *testing.T) {
+	config := NewConfig()
+	config.setMappingFile("./tests/test-redirect-map.yml")
+	config.setProxies([]string{"0.0.0.0"}, HeaderXForwardedFor)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+
+	testData := []struct {
+		host               string
+		forwardedHost      string
+		target             string
+		expectedStatusCode int
+	}{
+		{"ingress.internal", "testhost", "/direct", 302},      // matched on the forwarded host
+		{"ingress.internal", "", "/direct", 404},              // the rewritten Host alone matches nothing
+		{"testhost", "elsewhere.example.org", "/direct", 404}, // the forwarded host wins over the Host header
+		{"example.com", "localhost", "/metrics", 404},         // localhost guards only trust the Host header
+	}
+
+	for _, test := range testData {
+		request := httptest.NewRequest("GET", test.target, nil)
+		request.Host = test.host
+		if test.forwardedHost != "" {
+			request.Header.Set(HeaderXForwardedHost, test.forwardedHost)
+		}
+
+		if resp, err := fastServer.server.Test(request); err != nil {
+			t.Errorf("Did not expect to get an error testing target [%s], error: %v", test.target, err)
+		} else if resp.StatusCode != test.expectedStatusCode {
+			t.Errorf("Expected [%d] for [%s%s] forwarded for [%s], got [%d]", test.expectedStatusCode, test.host, test.target, test.forwardedHost, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"go-redirector/certstore"
+	"go-redirector/mapping"
+	"go-redirector/metrics"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// fileStamp captures enough of a file's state to notice when it has been rewritten.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func (s fileStamp) equal(other fileStamp) bool {
+	return s.modTime.Equal(other.modTime) && s.size == other.size
+}
+
+func statFile(file string) (fileStamp, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileStamp{}, err
+	}
+
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+/*
+*
+Reload the mapping file from the configured path. The new file is only swapped in once it has
+been parsed and validated, and when serving tls once its hosts are covered by certificates, on
+any error the mappings currently in use are kept.
+*/
+func (f *FastServer) reloadMappingFile() error {
+	f.reloadMu.Lock()
+	defer f.reloadMu.Unlock()
+	path := f.Config.MappingPath
+
+	mappingFile, err := mapping.LoadMappingFile(path)
+	var failures []string
+	if err == nil && f.Config.TestMappings {
+		if failures = runExpectations(mappingFile); len(failures) > 0 {
+			err = fmt.Errorf("%d of %d test(s) failed", len(failures), len(mappingFile.Tests))
+		}
+	}
+	var store *certstore.Store
+	if err == nil && f.CertStore() != nil {
+		store, err = f.Config.loadCerts(mappingFile)
+	}
+	f.PrometheusExporter.ObserveMappingLoad(metrics.LoadReload, err == nil)
+	f.setReloadError(err)
+	if validationErr, ok := err.(*mapping.ValidationError); ok {
+		log.Error().Msg(fmt.Sprintf("Could not reload mapping file [%s], keeping previous mappings: %d problem(s) found", path, len(validationErr.Problems)))
+		logProblems(validationErr)
+		return err
+	} else if err != nil {
+		log.Error().Msg(fmt.Sprintf("Could not reload mapping file [%s], keeping previous mappings: %v", path, err))
+		logFailures(failures)
+		return err
+	}
+
+	if store != nil {
+		f.setCertsError(nil)
+		f.swapCertStore(store)
+	}
+	f.swapMappingFile(mappingFile)
+	log.Info().Msg(fmt.Sprintf("Reloaded mappings for [%d] host(s) from [%s].", len(mappingFile.Mappings), path))
+
+	return nil
+}
+
+// logProblems logs each problem of a mapping file on its own line
+func logProblems(validationErr *mapping.ValidationError) {
+	for _, problem := range validationErr.Problems {
+		log.Error().
+			Str("host", problem.Host).
+			Str("path", problem.Path).
+			Str("test", problem.Test).
+			Str("rule", problem.Rule).
+			Int("line", problem.Line).
+			Int("column", problem.Column).
+			Msg(problem.Message)
+	}
+}
+
+// logFailures logs each failed test of a mapping file on its own line
+func logFailures(failures []string) {
+	for _, failure := range failures {
+		log.Error().Msg(failure)
+	}
+}
+
+/*
+*
+Watch the mapping file, reloading it whenever it changes on disk or the process receives a SIGHUP.
+A zero interval disables polling the file, SIGHUP is still honoured. Closing `stop` ends the watch.
+*/
+func (f *FastServer) watchMappingFile(interval time.Duration, stop <-chan struct{}) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	lastStamp, _ := statFile(f.Config.MappingPath)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hangup:
+			log.Info().Msg("Received SIGHUP, reloading mapping file")
+			lastStamp, _ = statFile(f.Config.MappingPath)
+			_ = f.reloadMappingFile()
+		case <-tick:
+			stamp, err := statFile(f.Config.MappingPath)
+			if err != nil {
+				log.Debug().Msg(fmt.Sprintf("Could not stat mapping file [%s]: %v", f.Config.MappingPath, err))
+				continue
+			}
+			if stamp.equal(lastStamp) {
+				continue
+			}
+
+			log.Info().Msg(fmt.Sprintf("Mapping file [%s] changed on disk, reloading", f.Config.MappingPath))
+			lastStamp = stamp
+			_ = f.reloadMappingFile()
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const reloadedMappingFile = `---
+mapping:
+  reloadhost:
+    "/":
+      redirect: https://localhost:8090
+`
+
+func copyFile(t *testing.T, src string, dst string) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatalf("Test harness could not read [%s]: %v", src, err)
+	}
+	if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", dst, err)
+	}
+}
+
+func newReloadServer(t *testing.T) (*FastServer, string) {
+	mappingPath := filepath.Join(t.TempDir(), "redirect-map.yml")
+	copyFile(t, "./tests/test-redirect-map.yml", mappingPath)
+
+	config := NewConfig()
+	config.setMappingFile(mappingPath)
+	return NewFastServer(config, config.MappingsFile), mappingPath
+}
+
+func Test_ReloadMappingFile(t *testing.T) {
+	fastServer, mappingPath := newReloadServer(t)
+
+	if err := ioutil.WriteFile(mappingPath, []byte(reloadedMappingFile), 0644); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", mappingPath, err)
+	}
+	if err := fastServer.reloadMappingFile(); err != nil {
+		t.Errorf("Expected reload of a valid mapping file to succeed, got: %v", err)
+	}
+	if _, err := fastServer.MappingFile().GetMappingEntry("reloadhost", "/"); err != nil {
+		t.Errorf("Expected to find the reloaded host, got: %v", err)
+	}
+
+	// a bad file must not replace the mappings in use
+	copyFile(t, "./tests/bad-redirect-map.yml", mappingPath)
+	if err := fastServer.reloadMappingFile(); err == nil {
+		t.Errorf("Expected reload of a bad mapping file to fail")
+	}
+	if _, err := fastServer.MappingFile().GetMappingEntry("reloadhost", "/"); err != nil {
+		t.Errorf("Expected the previous mappings to be kept after a failed reload, got: %v", err)
+	}
+}
+
+func Test_ReloadMappingFileTests(t *testing.T) {
+	fastServer, mappingPath := newReloadServer(t)
+	fastServer.Config.TestMappings = true
+
+	// a valid file whose tests fail must not replace the mappings in use
+	copyFile(t, "./tests/failing-tests-redirect-map.yml", mappingPath)
+	if err := fastServer.reloadMappingFile(); err == nil {
+		t.Errorf("Expected reload of a mapping file with failing tests to fail")
+	}
+	if _, err := fastServer.MappingFile().GetMappingEntry("testedhost", "/docs/install"); err == nil {
+		t.Errorf("Expected the previous mappings to be kept after failing tests")
+	}
+
+	copyFile(t, "./tests/tested-redirect-map.yml", mappingPath)
+	if err := fastServer.reloadMappingFile(); err != nil {
+		t.Errorf("Expected reload of a mapping file with passing tests to succeed, got: %v", err)
+	}
+}
+
+func Test_WatchMappingFile(t *testing.T) {
+	fastServer, mappingPath := newReloadServer(t)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go fastServer.watchMappingFile(10*time.Millisecond, stop)
+	time.Sleep(50 * time.Millisecond) // let the watcher record the initial file
+
+	if err := ioutil.WriteFile(mappingPath, []byte(reloadedMappingFile), 0644); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", mappingPath, err)
+	}
+	// make sure the change is visible even on file systems with coarse timestamps
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(mappingPath, future, future); err != nil {
+		t.Fatalf("Test harness could not touch [%s]: %v", mappingPath, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := fastServer.MappingFile().GetMappingEntry("reloadhost", "/"); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected the watcher to reload the changed mapping file")
+}
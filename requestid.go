@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"go-redirector/errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultRequestIDHeader is the default header a request id is read from and echoed in
+	DefaultRequestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength is the longest incoming request id accepted, longer ones are replaced
+	maxRequestIDLength = 128
+)
+
+/*
+*
+Set the header request ids are read from and echoed in, empty is DefaultRequestIDHeader. The name
+must be a valid header token.
+*/
+func (c *Config) setRequestIDHeader(header string) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+
+	for _, r := range header {
+		if !isTokenChar(r) {
+			log.Error().Msg(fmt.Sprintf("Request id header [%s] is not a valid header name", header))
+			c.exitFunc(errors.ExitCodeBadRequestIDHeader)
+			return
+		}
+	}
+
+	c.RequestIDHeader = header
+}
+
+// isTokenChar reports whether r may be used in a header name, see RFC 7230
+func isTokenChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
+
+// validRequestID accepts ids made of letters, digits and the punctuation of uuids, hex and base64 encodings
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:/+=@", r)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID generates a random 128 bit id, hex encoded
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Error().Msg(fmt.Sprintf("Could not generate a request id: %v", err))
+		return ""
+	}
+
+	return hex.EncodeToString(id)
+}
+
+/*
+*
+Find the id of a request, the incoming one when it is valid, otherwise a new one. The id is echoed
+in the response headers and a logger carrying it is returned, so every event of the request can be
+correlated.
+*/
+func (f *FastServer) requestID(c *fiber.Ctx) (string, zerolog.Logger) {
+	header := f.Config.RequestIDHeader
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+
+	id := c.Get(header)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	c.Set(header, id)
+
+	return id, log.With().Str("request_id", id).Logger()
+}
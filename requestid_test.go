@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"go-redirector/accesslog"
+	"go-redirector/errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func Test_SetRequestIDHeader(t *testing.T) {
+	config := NewConfig()
+	config.exitFunc = func(code int) {
+		t.Errorf("Did not expect to see the app exit on a valid header, code: %d", code)
+	}
+	if config.setRequestIDHeader(""); config.RequestIDHeader != DefaultRequestIDHeader {
+		t.Errorf("Expected an empty header to be [%s], got [%s]", DefaultRequestIDHeader, config.RequestIDHeader)
+	}
+	if config.setRequestIDHeader("X-Correlation-ID"); config.RequestIDHeader != "X-Correlation-ID" {
+		t.Errorf("Expected [X-Correlation-ID], got [%s]", config.RequestIDHeader)
+	}
+
+	exitReached := false
+	config.exitFunc = func(code int) {
+		if code != errors.ExitCodeBadRequestIDHeader {
+			t.Errorf("Expected exit code of [%v], got [%v]", errors.ExitCodeBadRequestIDHeader, code)
+		}
+		exitReached = true
+	}
+	config.setRequestIDHeader("X Request: ID")
+	if !exitReached {
+		t.Errorf("Expected the app to exit for an invalid header name")
+	}
+}
+
+func Test_ValidRequestID(t *testing.T) {
+	for _, id := range []string{"abc123", "0f8fad5b-d9cb-469f-a165-70867728950e", "dGVzdA==", "lb:1/2@edge_3.4"} {
+		if !validRequestID(id) {
+			t.Errorf("Expected [%s] to be a valid request id", id)
+		}
+	}
+	for _, id := range []string{"", "has space", `<script>`, "quote\"", strings.Repeat("a", maxRequestIDLength+1)} {
+		if validRequestID(id) {
+			t.Errorf("Expected [%s] to be an invalid request id", id)
+		}
+	}
+
+	first, second := newRequestID(), newRequestID()
+	if len(first) != 32 || !validRequestID(first) || first == second {
+		t.Errorf("Expected distinct 32 character ids, got [%s] and [%s]", first, second)
+	}
+}
+
+func Test_RequestIDPropagation(t *testing.T) {
+	defer func(logger zerolog.Logger, level zerolog.Level) {
+		log.Logger = logger
+		zerolog.SetGlobalLevel(level)
+	}(log.Logger, zerolog.GlobalLevel())
+
+	var appLog, accessLog bytes.Buffer
+	log.Logger = zerolog.New(&appLog)
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+
+	config := NewConfig()
+	config.setMappingFile("./tests/test-redirect-map.yml")
+	config.setRequestIDHeader("X-Correlation-ID")
+	config.AccessLog, _ = accesslog.New(accesslog.FormatLogfmt, &accessLog, false)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+
+	testData := []struct {
+		target     string
+		incoming   string
+		statusCode int
+	}{
+		{"/my-path", "support-42", 200},
+		{"/direct", "support-43", 302},
+		{"/missing", "support-44", 404},
+		{"/favicon", "support-45", 404},
+		{"/my-path", "", 200},
+		{"/my-path", "<not valid>", 200},
+	}
+
+	for _, test := range testData {
+		appLog.Reset()
+		accessLog.Reset()
+		request := httptest.NewRequest("GET", test.target, nil)
+		request.Host = "testhost"
+		if test.incoming != "" {
+			request.Header.Set("X-Correlation-ID", test.incoming)
+		}
+
+		resp, err := fastServer.server.Test(request)
+		if err != nil {
+			t.Fatalf("Did not expect to get an error testing target [%s], error: %v", test.target, err)
+		}
+		if resp.StatusCode != test.statusCode {
+			t.Errorf("Expected [%d] for [%s], got [%d]", test.statusCode, test.target, resp.StatusCode)
+		}
+
+		id := resp.Header.Get("X-Correlation-ID")
+		if validRequestID(test.incoming) {
+			if id != test.incoming {
+				t.Errorf("Expected the incoming id [%s] to be echoed, got [%s]", test.incoming, id)
+			}
+		} else if id == test.incoming || !validRequestID(id) {
+			t.Errorf("Expected a generated id for incoming [%s], got [%s]", test.incoming, id)
+		}
+
+		if !strings.Contains(accessLog.String(), "request_id="+id) {
+			t.Errorf("Expected the access log entry for [%s] to carry [%s], got [%s]", test.target, id, accessLog.String())
+		}
+		if test.statusCode == 404 && appLog.Len() == 0 {
+			t.Errorf("Expected debug events for [%s]", test.target)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(appLog.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			if !strings.Contains(line, `"request_id":"`+id+`"`) {
+				t.Errorf("Expected every event for [%s] to carry [%s], got [%s]", test.target, id, line)
+			}
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		if test.statusCode == 200 && !strings.Contains(string(body), "<code>"+id+"</code>") {
+			t.Errorf("Expected the friendly page to show [%s], got [%s]", id, body)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"go-redirector/errors"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli"
+)
+
+// Draining reports whether the server is shutting down, probes fail while it drains.
+func (f *FastServer) Draining() bool {
+	return atomic.LoadInt32(&f.draining) == 1
+}
+
+/*
+*
+Shut the server down gracefully. Readiness fails first, so load balancers stop sending requests
+during the drain period, then the listeners are closed and in-flight requests are given until the
+timeout to finish. A signal on `skip` cuts the drain period short. Times out with an exit error
+using ExitCodeShutdownTimeout.
+*/
+func (f *FastServer) shutdown(drain time.Duration, timeout time.Duration, skip <-chan os.Signal) error {
+	atomic.StoreInt32(&f.draining, 1)
+	f.stopWatching()
+
+	if drain > 0 {
+		log.Info().Msg(fmt.Sprintf("Draining for [%s] before shutting down", drain))
+		select {
+		case <-time.After(drain):
+		case sig := <-skip:
+			log.Info().Msg(fmt.Sprintf("Received %s while draining, shutting down now", sig))
+		}
+	}
+
+	log.Info().Msg(fmt.Sprintf("Shutting down, waiting up to [%s] for in-flight requests", timeout))
+	done := make(chan error, 1)
+	go func() {
+		done <- f.server.Shutdown()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return cli.NewExitError(fmt.Sprintf("Requests still in flight after [%s], shutting down anyway", timeout), errors.ExitCodeShutdownTimeout)
+	}
+}
+
+// idleTimeout keeps idle keep-alive connections from outliving the shutdown timeout
+func idleTimeout(shutdownTimeout time.Duration) time.Duration {
+	if shutdownTimeout > 0 && shutdownTimeout/2 < DefaultIdleTimeout {
+		return shutdownTimeout / 2
+	}
+
+	return DefaultIdleTimeout
+}
+
+// stopWatching ends the mapping file watch, it is safe to call more than once
+func (f *FastServer) stopWatching() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
+/*
+*
+Run `listen` until it fails or SIGINT/SIGTERM is received, in which case the server is shut down
+gracefully, see shutdown.
+*/
+func (f *FastServer) serveUntilSignalled(listen func() error) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- listen()
+	}()
+
+	select {
+	case err := <-served:
+		f.stopWatching()
+		return err
+	case sig := <-signals:
+		log.Info().Msg(fmt.Sprintf("Received %s, shutting down", sig))
+		if err := f.shutdown(f.Config.DrainPeriod, f.Config.ShutdownTimeout, signals); err != nil {
+			return err
+		}
+		return <-served
+	}
+}
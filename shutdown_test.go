@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"go-redirector/errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/urfave/cli"
+)
+
+// listenServer serves the fiber app of the server on a free local port, returning its address and the result of serving
+func listenServer(t *testing.T, fastServer *FastServer) (string, chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Test harness could not listen: %v", err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- fastServer.server.Listener(ln)
+	}()
+
+	// any response means the server is serving, shutting down any earlier would not stop it
+	addr := ln.Addr().String()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for i := 0; i < 100; i++ {
+		if resp, err := client.Get(fmt.Sprintf("http://%s/favicon", addr)); err == nil {
+			resp.Body.Close()
+			return addr, served
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Test harness could not reach the server on [%s]", addr)
+	return addr, served
+}
+
+func Test_Shutdown(t *testing.T) {
+	config := NewConfig()
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+	_, served := listenServer(t, fastServer)
+
+	if fastServer.Draining() {
+		t.Errorf("Did not expect the server to drain before shutdown")
+	}
+	if err := fastServer.shutdown(0, time.Second, nil); err != nil {
+		t.Errorf("Did not expect an error shutting down an idle server, error: %v", err)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Did not expect an error from the listener, error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the listener to stop once shut down")
+	}
+
+	select {
+	case <-fastServer.stop:
+	default:
+		t.Errorf("Expected the mapping file watch to be stopped")
+	}
+
+	// probes fail while draining
+	request := httptest.NewRequest("GET", "/healthy", nil)
+	request.Host = "localhost"
+	if resp, err := fastServer.server.Test(request); err != nil {
+		t.Errorf("Did not expect to get an error testing /healthy, error: %v", err)
+	} else if resp.StatusCode != 503 {
+		t.Errorf("Expected [503] while draining, got [%d]", resp.StatusCode)
+	}
+}
+
+func Test_ShutdownTimeout(t *testing.T) {
+	config := NewConfig()
+	fastServer := NewFastServer(config, config.MappingsFile)
+
+	started := make(chan struct{})
+	fastServer.server.Get("/slow", func(c *fiber.Ctx) error {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		return c.SendStatus(200)
+	})
+	addr, _ := listenServer(t, fastServer)
+
+	requested := make(chan struct{})
+	go func() {
+		defer close(requested)
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		if resp, err := client.Get(fmt.Sprintf("http://%s/slow", addr)); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	err := fastServer.shutdown(0, 50*time.Millisecond, nil)
+	if exitErr, ok := err.(cli.ExitCoder); !ok {
+		t.Errorf("Expected an exit error with a request in flight, got: %v", err)
+	} else if exitErr.ExitCode() != errors.ExitCodeShutdownTimeout {
+		t.Errorf("Expected exit code of [%v], got [%v]", errors.ExitCodeShutdownTimeout, exitErr.ExitCode())
+	}
+	<-requested
+}
+
+func Test_ShutdownIdleConnection(t *testing.T) {
+	config := NewConfig()
+	config.setShutdown(0, time.Second)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+	addr, _ := listenServer(t, fastServer)
+
+	// a keep-alive connection left idle must not hold the shutdown up
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	if resp, err := (&http.Client{Transport: transport}).Get(fmt.Sprintf("http://%s/favicon", addr)); err != nil {
+		t.Fatalf("Test harness could not reach the server on [%s]: %v", addr, err)
+	} else {
+		resp.Body.Close()
+	}
+
+	if err := fastServer.shutdown(0, config.ShutdownTimeout, nil); err != nil {
+		t.Errorf("Did not expect an idle connection to time the shutdown out, error: %v", err)
+	}
+}
+
+func Test_IdleTimeout(t *testing.T) {
+	testData := []struct {
+		shutdownTimeout time.Duration
+		expected        time.Duration
+	}{
+		{DefaultShutdownTimeout, DefaultIdleTimeout},
+		{time.Minute, DefaultIdleTimeout},
+		{2 * time.Second, time.Second},
+		{0, DefaultIdleTimeout},
+	}
+
+	for _, test := range testData {
+		if actual := idleTimeout(test.shutdownTimeout); actual != test.expected {
+			t.Errorf("Expected idle timeout [%s] for shutdown timeout [%s], got [%s]", test.expected, test.shutdownTimeout, actual)
+		}
+	}
+}
+
+func Test_ShutdownSkipsDrain(t *testing.T) {
+	config := NewConfig()
+	fastServer := NewFastServer(config, config.MappingsFile)
+	fastServer.setup()
+	listenServer(t, fastServer)
+
+	skip := make(chan os.Signal, 1)
+	skip <- syscall.SIGTERM
+
+	start := time.Now()
+	if err := fastServer.shutdown(time.Minute, time.Second, skip); err != nil {
+		t.Errorf("Did not expect an error shutting down, error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected a second signal to cut the drain short, took [%s]", elapsed)
+	}
+}
+
+func Test_ServeUntilSignalledListenError(t *testing.T) {
+	config := NewConfig()
+	fastServer := NewFastServer(config, config.MappingsFile)
+
+	listenErr := fmt.Errorf("address already in use")
+	if err := fastServer.serveUntilSignalled(func() error { return listenErr }); err != listenErr {
+		t.Errorf("Expected the listen error to be returned, got: %v", err)
+	}
+
+	select {
+	case <-fastServer.stop:
+	default:
+		t.Errorf("Expected the mapping file watch to be stopped")
+	}
+}
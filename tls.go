@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"go-redirector/certstore"
+	"go-redirector/errors"
+	"go-redirector/mapping"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// hostCerts returns the certificate pairs set for hosts in the mapping file, by host key
+func hostCerts(mappingFile *mapping.MappingsFile) map[string]certstore.Pair {
+	pairs := map[string]certstore.Pair{}
+	for host, options := range mappingFile.Hosts {
+		if options != nil && options.Cert != "" {
+			pairs[host] = certstore.Pair{Cert: options.Cert, Key: options.Key}
+		}
+	}
+
+	return pairs
+}
+
+// mappedHosts returns every host key of the mapping file, sorted
+func mappedHosts(mappingFile *mapping.MappingsFile) []string {
+	hosts := make([]string, 0, len(mappingFile.Mappings))
+	for host := range mappingFile.Mappings {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	return hosts
+}
+
+/*
+*
+Load the certificates served for the hosts of a mapping file: the server cert as the default, every
+pair of the cert directory and the pairs set per host in the mapping file. Fails when a mapped host
+is not served a certificate valid for it, warns that wildcard hosts are only covered one label deep.
+*/
+func (c *Config) loadCerts(mappingFile *mapping.MappingsFile) (*certstore.Store, error) {
+	store, err := certstore.Load(certstore.Pair{Cert: c.ServerCert, Key: c.ServerKey}, c.CertDir, hostCerts(mappingFile))
+	if err != nil {
+		return nil, err
+	}
+
+	if uncovered := store.Uncovered(mappedHosts(mappingFile)); len(uncovered) > 0 {
+		return nil, &uncoveredError{uncovered}
+	}
+	for _, host := range mappedHosts(mappingFile) {
+		if strings.HasPrefix(host, mapping.WildcardHostPrefix) {
+			log.Warn().Msg(fmt.Sprintf("Wildcard host [%s] also maps names more than one label below it, a [%s] certificate does not cover them, they are served their own certificate or the default one", host, host))
+		}
+	}
+
+	return store, nil
+}
+
+// uncoveredError lists the mapped hosts no certificate is valid for
+type uncoveredError struct {
+	hosts []string
+}
+
+func (e *uncoveredError) Error() string {
+	return fmt.Sprintf("no certificate covers host(s) [%s]", strings.Join(e.hosts, ", "))
+}
+
+/*
+*
+Set the directory of certificate and key pairs served by SNI next to the server cert, and load every
+certificate. Exits when one cannot be loaded or a mapped host is not covered. Nothing is loaded when
+the server only serves plain http or uses acme, or no server cert is set, listening then fails.
+*/
+func (c *Config) setCerts(certDir string) {
+	c.CertDir = certDir
+	if c.UseHTTP || c.UseACME || c.MappingsFile == nil || c.ServerCert == "" {
+		return
+	}
+
+	store, err := c.loadCerts(c.MappingsFile)
+	if _, ok := err.(*uncoveredError); ok {
+		log.Error().Msg(fmt.Sprintf("Error: %v", err))
+		c.exitFunc(errors.ExitCodeUncoveredHosts)
+		return
+	} else if err != nil {
+		log.Error().Msg(fmt.Sprintf("Error: %v", err))
+		c.exitFunc(errors.ExitCodeBadCert)
+		return
+	}
+
+	c.Certs = store
+	logCerts(store)
+}
+
+// logCerts logs every certificate served and when it expires, warning about expired ones
+func logCerts(store *certstore.Store) {
+	for _, info := range store.Infos() {
+		if time.Now().After(info.NotAfter) {
+			log.Warn().Msg(fmt.Sprintf("Certificate [%s] for [%s] expired at [%s]", info.File, strings.Join(info.Names, ", "), info.NotAfter.Format(time.RFC3339)))
+			continue
+		}
+		log.Info().Msg(fmt.Sprintf("Serving certificate [%s] for [%s], expires at [%s]", info.File, strings.Join(info.Names, ", "), info.NotAfter.Format(time.RFC3339)))
+	}
+}
+
+// CertStore returns the certificates currently served, nil when the server only serves plain http.
+func (f *FastServer) CertStore() *certstore.Store {
+	store, _ := f.certStore.Load().(*certstore.Store)
+	return store
+}
+
+// swapCertStore atomically replaces the certificates served, new handshakes use them straight away.
+func (f *FastServer) swapCertStore(store *certstore.Store) {
+	if store != nil {
+		f.certStore.Store(store)
+	}
+}
+
+// setCertsError records the outcome of the last certificate reload, nil clears a previous error
+func (f *FastServer) setCertsError(err error) {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	f.certsErr = err
+}
+
+/*
+*
+Reload the certificates served for the mapping file in use. They are only swapped in once every
+pair has been loaded, its key matching its certificate, and every mapped host is covered, on any
+error the certificates currently served are kept. Handshakes in flight finish with the old ones.
+*/
+func (f *FastServer) reloadCerts() error {
+	f.reloadMu.Lock()
+	defer f.reloadMu.Unlock()
+
+	store, err := f.Config.loadCerts(f.MappingFile())
+	f.setCertsError(err)
+	if err != nil {
+		log.Error().Msg(fmt.Sprintf("Could not reload certificates, keeping the certificates in use: %v", err))
+		return err
+	}
+
+	f.swapCertStore(store)
+	log.Info().Msg(fmt.Sprintf("Reloaded [%d] certificate(s).", len(store.Infos())))
+	logCerts(store)
+
+	return nil
+}
+
+// certFiles lists the files certificates are loaded from, the cert directory included so pairs added to it are noticed
+func (f *FastServer) certFiles() []string {
+	files := []string{f.Config.ServerCert, f.Config.ServerKey}
+	if f.Config.CertDir != "" {
+		files = append(files, f.Config.CertDir)
+		pairs, _ := certstore.DirPairs(f.Config.CertDir)
+		for _, pair := range pairs {
+			files = append(files, pair.Cert, pair.Key)
+		}
+	}
+	if mappingFile := f.MappingFile(); mappingFile != nil {
+		for _, pair := range hostCerts(mappingFile) {
+			files = append(files, pair.Cert, pair.Key)
+		}
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// certStamps captures the state of every certificate file, missing files included so removals are noticed
+func (f *FastServer) certStamps() map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	for _, file := range f.certFiles() {
+		stamps[file], _ = statFile(file)
+	}
+
+	return stamps
+}
+
+// changed reports whether any certificate file differs between two captures
+func changed(previous map[string]fileStamp, current map[string]fileStamp) bool {
+	if len(previous) != len(current) {
+		return true
+	}
+	for file, stamp := range current {
+		if last, ok := previous[file]; !ok || !stamp.equal(last) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+*
+Watch the certificate files, reloading the certificates whenever one of them is rewritten, for
+instance when cert-manager rotates a mounted secret. A zero interval disables polling, a SIGHUP
+still reloads them with the mapping file. Closing `stop` ends the watch.
+*/
+func (f *FastServer) watchCerts(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastStamps := f.certStamps()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			stamps := f.certStamps()
+			if !changed(lastStamps, stamps) {
+				continue
+			}
+
+			// a pair rewritten one file at a time is retried once its other file changes
+			log.Info().Msg("Certificate files changed on disk, reloading")
+			lastStamps = stamps
+			_ = f.reloadCerts()
+		}
+	}
+}
+
+// getCertificate selects the certificate for a handshake from the certificates currently served
+func (f *FastServer) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	store := f.CertStore()
+	if store == nil {
+		return nil, fmt.Errorf("no certificates are loaded")
+	}
+
+	return store.GetCertificate(hello)
+}
+
+// listenTLS serves https on `addr`, selecting the certificate of each handshake by SNI
+func (f *FastServer) listenTLS(server *fiber.App, addr string) error {
+	if f.acme == nil && f.CertStore() == nil {
+		return fmt.Errorf("tls: provide a valid cert or key path")
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return f.serveTLS(server, ln)
+}
+
+// serveTLS serves https on a listener, with the certificates of the acme manager when using acme
+func (f *FastServer) serveTLS(server *fiber.App, ln net.Listener) error {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: f.getCertificate,
+	}
+	if f.acme != nil {
+		config = f.acmeTLSConfig()
+	}
+
+	return server.Listener(tls.NewListener(ln, config))
+}
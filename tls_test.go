@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"go-redirector/errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// writeTestPair writes a self signed certificate for `names` valid for a day and its key to `dir`, as `file.pem` and `file.key`
+func writeTestPair(t *testing.T, dir string, file string, names ...string) (string, string) {
+	return writeTestPairUntil(t, dir, file, time.Now().Add(24*time.Hour), names...)
+}
+
+// writeTestPairUntil writes a self signed certificate for `names` expiring at `notAfter` and its key to `dir`, see writeTestPair
+func writeTestPairUntil(t *testing.T, dir string, file string, notAfter time.Time, names ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Test harness could not generate a key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Test harness could not create a certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Test harness could not marshal a key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, file+".pem"), filepath.Join(dir, file+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", certFile, err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", keyFile, err)
+	}
+
+	return certFile, keyFile
+}
+
+// writeMapping writes a mapping file for `hosts`, setting the cert and key of hosts which have them
+func writeMapping(t *testing.T, hosts map[string][2]string) string {
+	content := "---\nhosts:\n"
+	for host, pair := range hosts {
+		if pair[0] != "" {
+			content += fmt.Sprintf("  %q:\n    cert: %s\n    key: %s\n", host, pair[0], pair[1])
+		}
+	}
+	content += "mapping:\n"
+	for host := range hosts {
+		content += fmt.Sprintf("  %q:\n    \"/\":\n      redirect: https://new.example.org\n", host)
+	}
+
+	mappingPath := filepath.Join(t.TempDir(), "redirect-map.yml")
+	if err := ioutil.WriteFile(mappingPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Test harness could not write [%s]: %v", mappingPath, err)
+	}
+
+	return mappingPath
+}
+
+// newTLSConfig creates a config serving tls with a default cert for `default.example.org` and a cert directory
+func newTLSConfig(t *testing.T, mappingPath string) (*Config, string) {
+	certDir := t.TempDir()
+	cert, key := writeTestPair(t, t.TempDir(), "server", "default.example.org")
+
+	config := NewConfig()
+	config.setHTTP(false, cert, key)
+	config.setMappingFile(mappingPath)
+	return config, certDir
+}
+
+func Test_SetCerts(t *testing.T) {
+	hostCert, hostKey := writeTestPair(t, t.TempDir(), "shop", "shop.example.org")
+	mappingPath := writeMapping(t, map[string][2]string{
+		"default.example.org":  {},
+		"blog.example.org":     {},
+		"*.legacy.example.org": {},
+		"shop.example.org":     {hostCert, hostKey},
+	})
+
+	config, certDir := newTLSConfig(t, mappingPath)
+	writeTestPair(t, certDir, "blog", "blog.example.org")
+	writeTestPair(t, certDir, "legacy", "*.legacy.example.org")
+	config.exitFunc = func(code int) {
+		t.Errorf("Did not expect to see the app exit when every host is covered, code: %d", code)
+	}
+	config.setCerts(certDir)
+	if config.Certs == nil {
+		t.Fatalf("Expected the certificates to be loaded")
+	}
+
+	// hosts without a valid certificate stop the server from starting
+	config, certDir = newTLSConfig(t, mappingPath)
+	writeTestPair(t, certDir, "blog", "blog.example.org")
+	exitCode := -1
+	config.exitFunc = func(code int) {
+		exitCode = code
+	}
+	if config.setCerts(certDir); exitCode != errors.ExitCodeUncoveredHosts || config.Certs != nil {
+		t.Errorf("Expected exit code of [%v] with [*.legacy.example.org] uncovered, got [%v]", errors.ExitCodeUncoveredHosts, exitCode)
+	}
+
+	exitCode = -1
+	config.ServerKey = config.ServerCert
+	if config.setCerts(certDir); exitCode != errors.ExitCodeBadCert {
+		t.Errorf("Expected exit code of [%v] for a bad key, got [%v]", errors.ExitCodeBadCert, exitCode)
+	}
+
+	// a host's own pair must be issued for it
+	unrelatedCert, unrelatedKey := writeTestPair(t, t.TempDir(), "unrelated", "unrelated.example.net")
+	config, certDir = newTLSConfig(t, writeMapping(t, map[string][2]string{"shop.example.org": {unrelatedCert, unrelatedKey}}))
+	exitCode = -1
+	config.exitFunc = func(code int) {
+		exitCode = code
+	}
+	if config.setCerts(certDir); exitCode != errors.ExitCodeUncoveredHosts || config.Certs != nil {
+		t.Errorf("Expected exit code of [%v] with a pair issued for another host, got [%v]", errors.ExitCodeUncoveredHosts, exitCode)
+	}
+
+	// nothing to load in plain http
+	plain := NewConfig()
+	plain.setHTTP(true, "", "")
+	plain.setMappingFile(mappingPath)
+	plain.exitFunc = func(code int) {
+		t.Errorf("Did not expect to see the app exit in plain http, code: %d", code)
+	}
+	if plain.setCerts(certDir); plain.Certs != nil {
+		t.Errorf("Did not expect certificates to be loaded in plain http")
+	}
+}
+
+func Test_LoadCertsWildcardDepth(t *testing.T) {
+	defer func(logger zerolog.Logger) {
+		log.Logger = logger
+	}(log.Logger)
+	var appLog bytes.Buffer
+	log.Logger = zerolog.New(&appLog)
+
+	mappingPath := writeMapping(t, map[string][2]string{"*.legacy.example.org": {}})
+	config, certDir := newTLSConfig(t, mappingPath)
+	writeTestPair(t, certDir, "legacy", "*.legacy.example.org")
+	config.CertDir = certDir
+	if _, err := config.loadCerts(config.MappingsFile); err != nil {
+		t.Fatalf("Did not expect an error loading the certificates, error: %v", err)
+	}
+
+	// the mapping matches deeper names the wildcard certificate is not valid for
+	if !strings.Contains(appLog.String(), "Wildcard host [*.legacy.example.org] also maps names more than one label below it") {
+		t.Errorf("Expected a warning that deeper names are not covered, got [%s]", appLog.String())
+	}
+}
+
+func Test_ServeTLS(t *testing.T) {
+	mappingPath := writeMapping(t, map[string][2]string{"default.example.org": {}, "blog.example.org": {}})
+	config, certDir := newTLSConfig(t, mappingPath)
+	writeTestPair(t, certDir, "blog", "blog.example.org")
+	config.setCerts(certDir)
+
+	fastServer := NewFastServer(config, config.MappingsFile)
+	server := fastServer.setup()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Test harness could not listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- fastServer.serveTLS(server, ln)
+	}()
+	defer func() {
+		_ = server.Shutdown()
+		<-served
+	}()
+
+	for serverName, expected := range map[string]string{
+		"blog.example.org":    "blog.example.org",
+		"unknown.example.org": "default.example.org",
+	} {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true}) //nolint:gosec
+		if err != nil {
+			t.Fatalf("Did not expect an error connecting as [%s], error: %v", serverName, err)
+		}
+		if names := conn.ConnectionState().PeerCertificates[0].DNSNames; names[0] != expected {
+			t.Errorf("Expected the certificate of [%s] for [%s], got %v", expected, serverName, names)
+		}
+		conn.Close()
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		DisableKeepAlives: true,
+	}}
+	request, _ := http.NewRequest("GET", fmt.Sprintf("https://%s/", ln.Addr()), nil)
+	request.Host = "blog.example.org"
+	if resp, err := client.Do(request); err != nil {
+		t.Errorf("Did not expect an error requesting over tls, error: %v", err)
+	} else if resp.StatusCode != 200 {
+		t.Errorf("Expected the friendly page over tls, got [%d]", resp.StatusCode)
+	}
+
+	if err := NewFastServer(NewConfig(), nil).listenTLS(server, "127.0.0.1:0"); err == nil {
+		t.Errorf("Expected an error listening without certificates")
+	}
+}
+
+func Test_ReloadCerts(t *testing.T) {
+	mappingPath := writeMapping(t, map[string][2]string{"default.example.org": {}})
+	config, certDir := newTLSConfig(t, mappingPath)
+	config.setCerts(certDir)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	initial := fastServer.CertStore()
+
+	// a host no certificate covers must not be served
+	uncovered := writeMapping(t, map[string][2]string{"default.example.org": {}, "shop.example.org": {}})
+	copyFile(t, uncovered, mappingPath)
+	if err := fastServer.reloadMappingFile(); err == nil {
+		t.Errorf("Expected reload with an uncovered host to fail")
+	}
+	if _, err := fastServer.MappingFile().GetMappingEntry("shop.example.org", "/"); err == nil {
+		t.Errorf("Expected the previous mappings to be kept")
+	}
+
+	// the host brings its certificate along
+	cert, key := writeTestPair(t, t.TempDir(), "shop", "shop.example.org")
+	covered := writeMapping(t, map[string][2]string{"default.example.org": {}, "shop.example.org": {cert, key}})
+	copyFile(t, covered, mappingPath)
+	if err := fastServer.reloadMappingFile(); err != nil {
+		t.Errorf("Expected reload with a covered host to succeed, got: %v", err)
+	}
+	if fastServer.CertStore() == initial {
+		t.Errorf("Expected the certificates to be swapped")
+	}
+	served, _ := fastServer.getCertificate(&tls.ClientHelloInfo{ServerName: "shop.example.org"})
+	if served == nil || served.Leaf.DNSNames[0] != "shop.example.org" {
+		t.Errorf("Expected the certificate of the reloaded host to be served")
+	}
+}
+
+// servedSerial returns the serial of the certificate served for `serverName`
+func servedSerial(t *testing.T, fastServer *FastServer, serverName string) *big.Int {
+	cert, err := fastServer.getCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatalf("Did not expect an error selecting the certificate of [%s], error: %v", serverName, err)
+	}
+
+	return cert.Leaf.SerialNumber
+}
+
+func Test_RotateCerts(t *testing.T) {
+	mappingPath := writeMapping(t, map[string][2]string{"default.example.org": {}, "blog.example.org": {}})
+	config, certDir := newTLSConfig(t, mappingPath)
+	writeTestPair(t, certDir, "blog", "blog.example.org")
+	config.setCerts(certDir)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	initial := servedSerial(t, fastServer, "blog.example.org")
+
+	// a certificate whose key is not rotated yet is refused
+	otherDir := t.TempDir()
+	rotatedCert, rotatedKey := writeTestPair(t, otherDir, "blog", "blog.example.org")
+	copyFile(t, rotatedCert, filepath.Join(certDir, "blog.pem"))
+	if err := fastServer.reloadCerts(); err == nil {
+		t.Errorf("Expected reload with a mismatched pair to fail")
+	}
+	if served := servedSerial(t, fastServer, "blog.example.org"); served.Cmp(initial) != 0 {
+		t.Errorf("Expected the previous certificate to be kept, got serial [%s]", served)
+	}
+	if status := fastServer.healthStatus(); status.TLS.LastReloadError == "" {
+		t.Errorf("Expected the reload error to be reported, got [%+v]", status.TLS)
+	}
+
+	// once the key follows the rotated certificate is served
+	copyFile(t, rotatedKey, filepath.Join(certDir, "blog.key"))
+	if err := fastServer.reloadCerts(); err != nil {
+		t.Errorf("Expected reload with a rotated pair to succeed, got: %v", err)
+	}
+	if served := servedSerial(t, fastServer, "blog.example.org"); served.Cmp(initial) == 0 {
+		t.Errorf("Expected the rotated certificate to be served")
+	}
+
+	status := fastServer.healthStatus()
+	if status.TLS.LastReloadError != "" || len(status.TLS.Certificates) != 2 || status.TLS.LoadedAt == nil {
+		t.Errorf("Expected both certificates reported without error, got [%+v]", status.TLS)
+	}
+	if status.TLS.Certificates[0].File != config.ServerCert || !status.TLS.NotAfter.Equal(status.TLS.Certificates[0].NotAfter) {
+		t.Errorf("Expected the server cert reported first, got [%+v]", status.TLS.Certificates)
+	}
+}
+
+func Test_WatchCerts(t *testing.T) {
+	mappingPath := writeMapping(t, map[string][2]string{"default.example.org": {}})
+	config, certDir := newTLSConfig(t, mappingPath)
+	config.setCerts(certDir)
+	fastServer := NewFastServer(config, config.MappingsFile)
+	initial := servedSerial(t, fastServer, "default.example.org")
+
+	stop := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		fastServer.watchCerts(10*time.Millisecond, stop)
+		close(watched)
+	}()
+	defer func() {
+		close(stop)
+		<-watched
+	}()
+
+	time.Sleep(50 * time.Millisecond) // let the watcher record the initial files
+
+	// pairs added to the cert directory are picked up too
+	writeTestPair(t, certDir, "server", "default.example.org")
+	for deadline := time.Now().Add(5 * time.Second); servedSerial(t, fastServer, "default.example.org").Cmp(initial) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the certificate added to the cert directory to be served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
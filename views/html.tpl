@@ -6,6 +6,7 @@
 <p>The page you reached has moved to <a href="{{.RedirectURI}}">{{.RedirectURI}}</a>, please update your bookmarks.</p>
 <p>You will be automatically redirected to <a href="{{.RedirectURI}}">{{.RedirectURI}}</a> in <span id="countdown">15</span> seconds.</p>
 <p>Or click <a href="{{.RedirectURI}}">THIS LINK</a> to go there now.</p>
+{{if .RequestID}}<p><small>Reference: <code>{{.RequestID}}</code></small></p>{{end}}
 <script type="text/javascript">
 	let seconds = 15;
 